@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditMatch   = "match"
+	AuditTrigger = "trigger"
+	AuditCommand = "command"
+)
+
+// AuditRecord is an entry of the audit log. Each entry carries the hash of the previous one, so
+// editing or deleting an entry breaks the chain.
+type AuditRecord struct {
+	Seq           uint64    `json:"seq"`
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	CorrelationId string    `json:"correlation_id"`
+	HookName      string    `json:"hook_name,omitempty"`
+	HookId        int       `json:"hook_id,omitempty"`
+	Agent         string    `json:"agent"`
+	Project       string    `json:"project"`
+	Branch        string    `json:"branch"`
+	Environment   string    `json:"environment"`
+	PullRequest   int       `json:"pull_request,omitempty"`
+	Url           string    `json:"url,omitempty"`
+	Sha           string    `json:"sha,omitempty"`
+	// Actor is the user who caused the action: the pull request sender, or the commenter of a
+	// manual command.
+	Actor          string `json:"actor"`
+	Command        string `json:"command,omitempty"`
+	Outcome        string `json:"outcome,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	JenkinsProject string `json:"jenkins_project,omitempty"`
+	// Entry is the matched projects.yaml entry and EntrySource the file it is loaded from.
+	Entry         string `json:"entry,omitempty"`
+	EntrySource   string `json:"entry_source,omitempty"`
+	JenkinsStatus int    `json:"jenkins_status,omitempty"`
+	DryRun        bool   `json:"dry_run,omitempty"`
+	QueueUrl      string `json:"queue_url,omitempty"`
+	Error         string `json:"error,omitempty"`
+	PrevHash      string `json:"prev_hash"`
+
+	// Folded are the correlation ids of the debounced triggers folded into the deploy.
+	Folded []string `json:"folded,omitempty"`
+}
+
+// auditHashPattern matches the hash appended to the JSON of a record in an audit log line.
+var auditHashPattern = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// AuditLog appends hash-chained records to a file, one JSON object per line.
+type AuditLog struct {
+	mu           sync.Mutex
+	file         *os.File
+	environments map[string]bool
+	seq          uint64
+	lastHash     string
+}
+
+// auditLog is the audit log of deploy triggers, it is nil if auditing is disabled.
+var auditLog *AuditLog
+
+// openAuditLog opens an audit log to append to, the chain continues from its last record. Only
+// actions on the given environments are recorded, all are if none is given.
+func openAuditLog(filename string, environments []string) (*AuditLog, error) {
+	audit := &AuditLog{environments: make(map[string]bool)}
+	for _, env := range environments {
+		if env = strings.TrimSpace(env); env != "" {
+			audit.environments[env] = true
+		}
+	}
+	if f, err := os.Open(filename); err == nil {
+		result, err := verifyAuditLog(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Problems) > 0 {
+			return nil, fmt.Errorf("audit log %s is broken: %s", filename, result.Problems[0])
+		}
+		audit.seq, audit.lastHash = result.LastSeq, result.LastHash
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	audit.file = f
+	return audit, nil
+}
+
+func (audit *AuditLog) Close() error {
+	return audit.file.Close()
+}
+
+// Append chains the record to the log and writes it to disk. Records of environments not audited
+// are skipped.
+func (audit *AuditLog) Append(record AuditRecord) error {
+	if len(audit.environments) > 0 && !audit.environments[record.Environment] {
+		return nil
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	record.Seq, record.PrevHash, record.Time = audit.seq+1, audit.lastHash, record.Time.UTC()
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	hash := auditHash(b)
+	line := append(b[:len(b)-1], []byte(`,"hash":"`+hash+`"}`+"\n")...)
+	if _, err := audit.file.Write(line); err != nil {
+		return err
+	}
+	if err := audit.file.Sync(); err != nil {
+		return err
+	}
+	audit.seq, audit.lastHash = record.Seq, hash
+	return nil
+}
+
+func auditHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditVerification is the result of verifying an audit log.
+type AuditVerification struct {
+	LastSeq  uint64
+	LastHash string
+	Problems []string
+}
+
+// verifyAuditLog checks that every record hashes to its stored hash, and chains to the previous
+// record with consecutive sequence numbers. Deleting the newest records can only be detected by
+// comparing the last sequence number and hash with a copy kept elsewhere.
+func verifyAuditLog(r io.Reader) (AuditVerification, error) {
+	result := AuditVerification{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		m := auditHashPattern.FindSubmatchIndex(text)
+		if m == nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("line %d: no record hash", line))
+			continue
+		}
+		body := append(append([]byte{}, text[:m[0]]...), '}')
+		hash := string(text[m[2]:m[3]])
+		record := AuditRecord{}
+		if err := json.Unmarshal(body, &record); err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("line %d: invalid record: %s", line, err))
+			continue
+		}
+		if auditHash(body) != hash {
+			result.Problems = append(result.Problems, fmt.Sprintf("line %d: seq %d was edited, its hash does not match", line, record.Seq))
+		}
+		if record.Seq != result.LastSeq+1 {
+			result.Problems = append(result.Problems, fmt.Sprintf("line %d: seq %d follows seq %d, records were deleted or reordered", line, record.Seq, result.LastSeq))
+		}
+		if record.PrevHash != result.LastHash {
+			result.Problems = append(result.Problems, fmt.Sprintf("line %d: seq %d does not chain to the previous record", line, record.Seq))
+		}
+		result.LastSeq, result.LastHash = record.Seq, hash
+	}
+	return result, scanner.Err()
+}
+
+// auditMatch records the trigger decision on a parsed hook: matched, unmatched or ignored, with
+// the matched entry.
+func auditMatch(log Logger, record *DeploymentRecord, project JenkinsProject) {
+	if auditLog == nil {
+		return
+	}
+	err := auditLog.Append(AuditRecord{
+		Time:           time.Now(),
+		Action:         AuditMatch,
+		CorrelationId:  record.CorrelationId,
+		HookName:       record.HookName,
+		HookId:         record.HookId,
+		Agent:          record.Agent,
+		Project:        record.Project,
+		Branch:         record.Branch,
+		Environment:    record.Environment,
+		PullRequest:    record.Number,
+		Url:            record.Url,
+		Sha:            record.Sha,
+		Actor:          record.Sender,
+		Outcome:        record.Status,
+		Reason:         record.MatchReason,
+		JenkinsProject: project.Name,
+		Entry:          project.Entry,
+		EntrySource:    project.Source,
+		DryRun:         record.DryRun,
+	})
+	if err != nil {
+		log.Error("write audit record failed", "error", err)
+	}
+}
+
+// auditDeploy records the Jenkins notify of a deploy.
+func auditDeploy(event *DeployEvent, notifier *JenkinsNotifier, notifyErr error) {
+	if auditLog == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:           time.Now(),
+		Action:         AuditTrigger,
+		CorrelationId:  event.CorrelationId,
+		HookName:       notifier.HookName,
+		HookId:         notifier.HookId,
+		Agent:          event.Agent,
+		Project:        event.Project,
+		Branch:         event.Branch,
+		Environment:    event.Environment,
+		PullRequest:    event.Number,
+		Url:            event.Url,
+		Sha:            event.Sha,
+		Actor:          event.Sender,
+		Command:        event.Command,
+		JenkinsProject: event.JenkinsProject,
+		Entry:          notifier.JenkinsProject.Entry,
+		EntrySource:    notifier.JenkinsProject.Source,
+		JenkinsStatus:  notifier.NotifyStatus,
+		DryRun:         notifier.DryRun,
+		QueueUrl:       notifier.QueueUrl,
+		Folded:         event.Folded,
+	}
+	if event.RequestedBy != "" {
+		record.Actor = event.RequestedBy
+	}
+	if notifyErr != nil {
+		record.Error = notifyErr.Error()
+	}
+	if err := auditLog.Append(record); err != nil {
+		event.logger().Error("write audit record failed", "error", err)
+	}
+}
+
+// auditCommand records a comment command and its outcome.
+func auditCommand(log Logger, hook BasicHook, agent CommentAgent, environment string, command CommentCommand, user, outcome string) {
+	if auditLog == nil {
+		return
+	}
+	pr := agent.PullRequest()
+	err := auditLog.Append(AuditRecord{
+		Time:          time.Now(),
+		Action:        AuditCommand,
+		CorrelationId: log.CorrelationId,
+		HookName:      hook.HookName,
+		HookId:        hook.HookId,
+		Agent:         agent.Name(),
+		Project:       agent.HookProject(),
+		Branch:        pr.Base.Ref,
+		Environment:   environment,
+		PullRequest:   pr.Number,
+		Url:           agent.Comment().HtmlUrl,
+		Actor:         user,
+		Command:       command.String(),
+		Outcome:       outcome,
+	})
+	if err != nil {
+		log.Error("write audit record failed", "error", err)
+	}
+}
+
+// runVerifyAudit is the verify-audit subcommand, it exits non-zero if the audit log is broken.
+func runVerifyAudit(args []string) int {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: prcd verify-audit <audit log file>")
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	result, err := verifyAuditLog(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, problem := range result.Problems {
+		fmt.Println(problem)
+	}
+	if len(result.Problems) > 0 {
+		fmt.Printf("audit log is broken: %d problems, last seq %d\n", len(result.Problems), result.LastSeq)
+		return 1
+	}
+	fmt.Printf("audit log ok: last seq %d, last hash %s\n", result.LastSeq, result.LastHash)
+	return 0
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestAuditLog(t *testing.T, environments ...string) string {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(filename, environments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []AuditRecord{
+		{Action: AuditTrigger, Environment: "production", Project: "toboto/mingdao", Actor: "toboto", JenkinsProject: "pro", JenkinsStatus: 201},
+		{Action: AuditTrigger, Environment: "debug", Project: "toboto/mingdao", Actor: "toboto"},
+		{Action: AuditCommand, Environment: "production", Project: "toboto/mingdao", Actor: "akimimi", Command: "/cancel"},
+		{Action: AuditTrigger, Environment: "production", Project: "toboto/mingdao", Actor: "akimimi", Command: "/redeploy"},
+	} {
+		if err := audit.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	audit.Close()
+	return filename
+}
+
+func verifyTestAuditLog(t *testing.T, b []byte) AuditVerification {
+	result, err := verifyAuditLog(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestAuditLog_Chain(t *testing.T) {
+	filename := writeTestAuditLog(t, "production")
+	b, _ := ioutil.ReadFile(filename)
+	result := verifyTestAuditLog(t, b)
+	if len(result.Problems) > 0 || result.LastSeq != 3 {
+		t.Fatalf("Audit log should verify with the production records only, actual %+v", result)
+	}
+
+	// Reopening continues the chain.
+	audit, err := openAuditLog(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Append(AuditRecord{Action: AuditTrigger, Environment: "debug"})
+	audit.Close()
+	b, _ = ioutil.ReadFile(filename)
+	if result := verifyTestAuditLog(t, b); len(result.Problems) > 0 || result.LastSeq != 4 {
+		t.Errorf("Reopened audit log should continue the chain, actual %+v", result)
+	}
+}
+
+func TestAuditLog_DetectsTampering(t *testing.T) {
+	b, _ := ioutil.ReadFile(writeTestAuditLog(t))
+	lines := strings.SplitAfter(string(b), "\n")
+
+	edited := strings.Replace(string(b), `"actor":"akimimi"`, `"actor":"toboto"`, 1)
+	if result := verifyTestAuditLog(t, []byte(edited)); len(result.Problems) != 1 || !strings.Contains(result.Problems[0], "line 3: seq 3 was edited") {
+		t.Errorf("An edited record should be detected, actual %v", result.Problems)
+	}
+
+	deleted := lines[0] + lines[2] + lines[3]
+	if result := verifyTestAuditLog(t, []byte(deleted)); len(result.Problems) == 0 || !strings.Contains(result.Problems[0], "seq 3 follows seq 1") {
+		t.Errorf("A deleted record should be detected, actual %v", result.Problems)
+	}
+
+	rehashed := strings.Replace(lines[1], `"environment":"debug"`, `"environment":"production"`, 1)
+	body := rehashed[:strings.Index(rehashed, `,"hash":`)] + "}"
+	rehashed = body[:len(body)-1] + `,"hash":"` + auditHash([]byte(body)) + `"}` + "\n"
+	if result := verifyTestAuditLog(t, []byte(lines[0]+rehashed+lines[2]+lines[3])); len(result.Problems) != 1 ||
+		!strings.Contains(result.Problems[0], "seq 3 does not chain") {
+		t.Errorf("A rehashed record should break the chain, actual %v", result.Problems)
+	}
+
+	if _, err := openAuditLog(writeEditedFile(t, edited), nil); err == nil {
+		t.Error("Opening a broken audit log should fail")
+	}
+}
+
+func writeEditedFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	ioutil.WriteFile(filename, []byte(content), 0640)
+	return filename
+}
+
+func TestRunVerifyAudit(t *testing.T) {
+	filename := writeTestAuditLog(t)
+	if code := runVerifyAudit([]string{filename}); code != 0 {
+		t.Errorf("verify-audit should succeed, actual exit code %d", code)
+	}
+	b, _ := ioutil.ReadFile(filename)
+	edited := writeEditedFile(t, strings.Replace(string(b), `"jenkins_status":201`, `"jenkins_status":200`, 1))
+	if code := runVerifyAudit([]string{edited}); code != 1 {
+		t.Errorf("verify-audit should fail on an edited log, actual exit code %d", code)
+	}
+	if code := runVerifyAudit(nil); code != 2 {
+		t.Errorf("verify-audit should require a file, actual exit code %d", code)
+	}
+}
+
+func TestAudit_CommentCommand(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if auditLog, err = openAuditLog(filename, []string{"production"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { auditLog.Close(); auditLog = nil }()
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer jenkins.Close()
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	loadCommandConfig("config/commands.sample.yaml")
+	defer loadCommandConfig("")
+	jenkinsProjectConfigGrp["release-backend"] = JenkinsProjectConfig{Environment: "production", VcsProject: "mimixiche-backend",
+		Branch: "develop", JenkinsProject: "pro", JenkinsToken: "abcd1234"}
+	host, notifyUrl := settings.jenkinsHost, settings.jenkinsNotifyUrl
+	defer func() {
+		settings.jenkinsHost, settings.jenkinsNotifyUrl, settings.webhookSecret = host, notifyUrl, ""
+		activeDeploysMu.Lock()
+		activeDeploys = make(map[string]activeDeploy)
+		activeDeploysMu.Unlock()
+	}()
+	settings.jenkinsHost, settings.jenkinsNotifyUrl = jenkins.URL, "/job/<project>/buildWithParameters?token=<token>&BRANCH=<branch>"
+	settings.webhookSecret = "hook-key"
+	notificationChannelGrp = nil
+
+	handleCommentCommand(context.Background(), Logger{CorrelationId: "delivery-1"}, BasicHook{HookName: "gitlab_note", HookId: 7}, loadNoteHookAgent(t, "/deploy production"))
+	b, _ := ioutil.ReadFile(filename)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"action":"command"`) || !strings.Contains(lines[1], `"action":"trigger"`) {
+		t.Fatalf("The command and the trigger should be audited, actual %s", b)
+	}
+	for _, expected := range []string{`"command":"/deploy production"`, `"jenkins_status":201`, `"hook_name":"gitlab_note"`, `"entry":"release-backend"`, `"jenkins_project":"pro"`, `"correlation_id":"delivery-1"`} {
+		if !strings.Contains(lines[1], expected) {
+			t.Errorf("Trigger record should contain %s, actual %s", expected, lines[1])
+		}
+	}
+	if result := verifyTestAuditLog(t, b); len(result.Problems) > 0 {
+		t.Errorf("Audit log should verify, actual %v", result.Problems)
+	}
+}
+
+func TestAudit_TriggerDecisions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if auditLog, err = openAuditLog(filename, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { auditLog.Close(); auditLog = nil }()
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	jenkinsProjectConfigGrp["release-mingdao"] = JenkinsProjectConfig{Environment: "production", VcsProject: "mingdao",
+		Branch: "master", JenkinsProject: "pro", JenkinsToken: "abcd1234", DryRun: true, Source: "projects.d/mingdao.yaml"}
+
+	b, _ := ioutil.ReadFile("samples/github_pull_request.json")
+	hook := BasicHook{HookName: githubHookName("pull_request"), HookId: 7}
+	sendNotice(context.Background(), "delivery-1", hook, b)
+	delete(jenkinsProjectConfigGrp, "release-mingdao")
+	sendNotice(context.Background(), "delivery-2", hook, b)
+	sendNotice(context.Background(), "delivery-3", BasicHook{HookName: "unknown_hooks"}, []byte(`{}`))
+
+	b, _ = ioutil.ReadFile(filename)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Every trigger decision should be audited, actual %s", b)
+	}
+	for i, expected := range [][]string{
+		{`"action":"match"`, `"outcome":"matched"`, `"hook_id":7`, `"entry":"release-mingdao"`, `"entry_source":"projects.d/mingdao.yaml"`},
+		{`"action":"trigger"`, `"correlation_id":"delivery-1"`, `"hook_id":7`, `"entry":"release-mingdao"`},
+		{`"action":"match"`, `"correlation_id":"delivery-2"`, `"outcome":"unmatched"`, `"reason":`},
+		{`"action":"match"`, `"hook_name":"unknown_hooks"`, `"outcome":"ignored"`},
+	} {
+		for _, field := range expected {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("Record %d should contain %s, actual %s", i+1, field, lines[i])
+			}
+		}
+	}
+}
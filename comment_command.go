@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gogap/errors"
+)
+
+// Commands accepted in pull request comments.
+const (
+	CommandDeploy   = "deploy"
+	CommandRedeploy = "redeploy"
+	CommandCancel   = "cancel"
+)
+
+// CommandAllowlistConfig defines the users who may run comment commands against an environment.
+// A user "*" allows everyone.
+type CommandAllowlistConfig struct {
+	Users []string `json:"users" yaml:"users"`
+}
+
+var commandAllowlistGrp map[string]CommandAllowlistConfig
+
+// loadCommandConfig loads the allowlist of comment commands, an empty filename allows no one. An
+// unreadable or invalid file is an error and allows no one.
+func loadCommandConfig(filename string) error {
+	commandAllowlistGrp = nil
+	if filename == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("load command config: %v", err)
+	}
+	var grp map[string]CommandAllowlistConfig
+	if err := yaml.UnmarshalStrict(b, &grp); err != nil {
+		return fmt.Errorf("load command config %s: %v", filename, err)
+	}
+	commandAllowlistGrp = grp
+	return nil
+}
+
+func isCommandAllowed(environment, user string) bool {
+	config, ok := commandAllowlistGrp[environment]
+	if !ok || user == "" {
+		return false
+	}
+	for _, u := range config.Users {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// CommentCommand is a "/command arg..." line in a comment.
+type CommentCommand struct {
+	Name string
+	Args []string
+}
+
+func (command CommentCommand) String() string {
+	return strings.Join(append([]string{"/" + command.Name}, command.Args...), " ")
+}
+
+// parseCommentCommand returns the command of the first line starting with "/".
+func parseCommentCommand(body string) (CommentCommand, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+		if name == CommandDeploy || name == CommandRedeploy || name == CommandCancel {
+			return CommentCommand{Name: name, Args: fields[1:]}, true
+		}
+	}
+	return CommentCommand{}, false
+}
+
+// commandAgent overrides the environment of a comment agent with the one chosen by the command,
+// so the deploy is matched and reported against it.
+type commandAgent struct {
+	CommentAgent
+	environment string
+	command     CommentCommand
+	user        string
+}
+
+// Environment returns the environment chosen by the command.
+func (agent *commandAgent) Environment() string {
+	return agent.environment
+}
+
+// handleCommentCommand runs the command in a pull request comment, replies with the outcome and
+// dispatches the deploy if one is requested.
+func handleCommentCommand(ctx context.Context, log Logger, hook BasicHook, agent CommentAgent) {
+	if agent.PullRequest().Number == 0 {
+		log.Debug("comment is not on a pull request, skip")
+		return
+	}
+	command, ok := parseCommentCommand(agent.Comment().Body)
+	if !ok {
+		return
+	}
+	// The commenter is taken from the payload, it can only be trusted if the hook is signed.
+	if settings.webhookSecret == "" {
+		log.Info("comment commands need -webhook-secret, skip", "command", command.Name,
+			"user", agent.Comment().User.Login)
+		return
+	}
+	user := agent.Comment().User.Login
+	log.Info("comment command", "command", command.Name, "args", strings.Join(command.Args, " "),
+		"user", user, "project", agent.HookProject(), "pull_request", agent.PullRequest().Number)
+
+	environment := agent.Environment()
+	if command.Name == CommandDeploy && len(command.Args) == 1 {
+		environment = command.Args[0]
+	} else if command.Name == CommandCancel {
+		if deploy, ok := findActiveDeploy(agent.HookProject(), agent.PullRequest().Number); ok {
+			environment = deploy.environment
+		}
+	}
+	reply, deployAgent, notifier := executeCommentCommand(agent, command, user)
+	auditCommand(log, hook, agent, environment, command, user, reply)
+	if settings.dryRun {
+		log.Info("dry run, skip reply", "reply", reply)
+	} else if err := agent.ReplyComment(reply); err != nil {
+		log.Error("reply comment failed", "error", err)
+	}
+	if notifier != nil {
+		notifier.CorrelationId, notifier.ctx = log.CorrelationId, ctx
+		notifier.HookName, notifier.HookId = hook.HookName, hook.HookId
+		dispatchDeploy(deployAgent, notifier)
+	}
+}
+
+// executeCommentCommand returns the reply of a command, and the deploy to dispatch if any.
+func executeCommentCommand(agent CommentAgent, command CommentCommand, user string) (string, HookAgent, *JenkinsNotifier) {
+	pr, project := agent.PullRequest(), agent.HookProject()
+	environment, branch, sha := agent.Environment(), pr.Base.Ref, pr.MergeCommitSha
+	switch command.Name {
+	case CommandDeploy:
+		if len(command.Args) != 1 {
+			return "Usage: `/deploy <environment>`", nil, nil
+		}
+		environment, branch, sha = command.Args[0], pr.Head.Ref, pr.Head.Sha
+	case CommandRedeploy:
+		if !pr.Merged && pr.State != "merged" {
+			return "Only merged pull requests can be redeployed.", nil, nil
+		}
+	case CommandCancel:
+		deploy, ok := findActiveDeploy(project, pr.Number)
+		if !ok {
+			return "No active deploy of this pull request to cancel.", nil, nil
+		}
+		if !isCommandAllowed(deploy.environment, user) {
+			return fmt.Sprintf("@%s is not allowed to cancel deploys to %s.", user, deploy.environment), nil, nil
+		}
+		if err := deploy.notifier.Cancel(); err != nil {
+			deploy.notifier.logger().Error("cancel jenkins build failed", "error", err)
+			return fmt.Sprintf("Failed to cancel Jenkins project %s: %s", deploy.notifier.JenkinsProject.Name, err), nil, nil
+		}
+		return fmt.Sprintf("Cancelled Jenkins project %s, requested by @%s.", deploy.notifier.JenkinsProject.Name, user), nil, nil
+	}
+
+	if !isCommandAllowed(environment, user) {
+		return fmt.Sprintf("@%s is not allowed to deploy to %s.", user, environment), nil, nil
+	}
+	jenkinsProject := matchJenkinsProject(environment, project, pr.Base.Ref)
+	if jenkinsProject.Name == "" || jenkinsProject.Token == "" {
+		return fmt.Sprintf("No Jenkins project is configured for environment=%s project=%s branch=%s.",
+			environment, project, pr.Base.Ref), nil, nil
+	}
+	notifier := createNotifier(jenkinsProject)
+	notifier.Branch, notifier.Sha = branch, sha
+	// The pull request head only reaches Jenkins through the url, without it the job builds its
+	// own branch.
+	if url := notifier.server().Url; command.Name == CommandDeploy && !strings.Contains(url, "<branch>") &&
+		!strings.Contains(url, "<sha>") {
+		return fmt.Sprintf("Cannot deploy `%s` to %s: the url of Jenkins project %s passes neither `<branch>` nor `<sha>`, "+
+			"so Jenkins would not build the pull request.", branch, environment, jenkinsProject.Name), nil, nil
+	}
+	reply := fmt.Sprintf("Deploying `%s` to %s with Jenkins project %s, requested by @%s.",
+		branch, environment, jenkinsProject.Name, user)
+	if notifier.DryRun {
+		reply += " This is a dry run, Jenkins is not notified."
+	}
+	return reply, &commandAgent{CommentAgent: agent, environment: environment, command: command, user: user}, notifier
+}
+
+// replyGiteeComment comments on a Gitee pull request, it is skipped if no Gitee token is configured.
+func replyGiteeComment(repository string, number int, body string) error {
+	if settings.giteeToken == "" {
+		logger.Info("gitee token is not configured, skip reply", "repository", repository, "pull_request", number)
+		return nil
+	}
+	b, err := json.Marshal(map[string]string{"access_token": settings.giteeToken, "body": body})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d/comments", strings.TrimSuffix(settings.giteeApiUrl, "/"), repository, number)
+	resp, err := vcsApiClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("Gitee api failed: repository=" + repository + " status=" + resp.Status)
+	}
+	return nil
+}
+
+// replyGithubComment comments on a GitHub pull request, it is skipped if no GitHub token is configured.
+func replyGithubComment(repository string, number int, body string) error {
+	if settings.githubToken == "" {
+		logger.Info("github token is not configured, skip reply", "repository", repository, "pull_request", number)
+		return nil
+	}
+	url := fmt.Sprintf("%s/repos/%s/issues/%d/comments", strings.TrimSuffix(settings.githubApiUrl, "/"), repository, number)
+	return githubApiRequest("POST", url, settings.githubToken, map[string]string{"body": body}, nil)
+}
+
+func fetchGithubPullRequest(repository string, number int) (PullRequest, error) {
+	pr := PullRequest{}
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d", strings.TrimSuffix(settings.githubApiUrl, "/"), repository, number)
+	err := githubApiRequest("GET", url, settings.githubToken, nil, &pr)
+	return pr, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommentCommand(t *testing.T) {
+	testData := map[string]CommentCommand{
+		"/deploy debug":               {Name: CommandDeploy, Args: []string{"debug"}},
+		"LGTM\n/Redeploy":             {Name: CommandRedeploy, Args: []string{}},
+		"  /cancel  ":                 {Name: CommandCancel, Args: []string{}},
+		"please /deploy\n/deploy a b": {Name: CommandDeploy, Args: []string{"a", "b"}},
+	}
+	for body, expected := range testData {
+		command, ok := parseCommentCommand(body)
+		if !ok || command.Name != expected.Name || strings.Join(command.Args, " ") != strings.Join(expected.Args, " ") {
+			t.Errorf("Command of %q error, expected %+v, actual %+v", body, expected, command)
+		}
+	}
+	for _, body := range []string{"", "LGTM", "/approve", "deploy debug"} {
+		if _, ok := parseCommentCommand(body); ok {
+			t.Errorf("%q should not be a command", body)
+		}
+	}
+}
+
+func TestIsCommandAllowed(t *testing.T) {
+	loadCommandConfig("config/commands.sample.yaml")
+	defer loadCommandConfig("")
+	if !isCommandAllowed("debug", "anyone") || !isCommandAllowed("production", "akimimi") {
+		t.Error("Allowlisted users should be allowed")
+	}
+	if isCommandAllowed("production", "anyone") || isCommandAllowed("staging", "akimimi") || isCommandAllowed("debug", "") {
+		t.Error("Users out of allowlist should not be allowed")
+	}
+
+	filename := filepath.Join(t.TempDir(), "commands.yaml")
+	ioutil.WriteFile(filename, []byte("production:\n  user:\n    - akimimi\n"), 0640)
+	if err := loadCommandConfig(filename); err == nil || !strings.Contains(err.Error(), "field user not found") {
+		t.Errorf("A typo in the allowlist should fail the load, actual %v", err)
+	}
+	if isCommandAllowed("production", "akimimi") {
+		t.Error("An invalid allowlist should allow no one")
+	}
+	if err := loadCommandConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("A missing allowlist should fail the load")
+	}
+}
+
+func loadNoteHookAgent(t *testing.T, body string) *NoteHookAgent {
+	agent := &NoteHookAgent{}
+	if file, e := ioutil.ReadFile("samples/note_hook.json"); e != nil {
+		panic(e)
+	} else {
+		agent.Parse(file)
+	}
+	agent.noteHook.Comment.Body = body
+	agent.noteHook.PullRequest.Base.Repo.Name = "mimixiche-backend"
+	return agent
+}
+
+func TestExecuteCommentCommand(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	loadCommandConfig("config/commands.sample.yaml")
+	defer loadCommandConfig("")
+	notifyUrl := settings.jenkinsNotifyUrl
+	defer func() { settings.jenkinsNotifyUrl = notifyUrl }()
+
+	agent := loadNoteHookAgent(t, "/deploy debug")
+	command, _ := parseCommentCommand(agent.Comment().Body)
+	settings.jenkinsNotifyUrl = "/job/<project>/build?token=<token>"
+	if reply, _, notifier := executeCommentCommand(agent, command, "toboto"); notifier != nil ||
+		!strings.Contains(reply, "Cannot deploy `dosomething` to debug") {
+		t.Errorf("Deploy command should be rejected if Jenkins cannot get the pull request head, actual %s", reply)
+	}
+	settings.jenkinsNotifyUrl = "/job/<project>/buildWithParameters?token=<token>&BRANCH=<branch>"
+	reply, deployAgent, notifier := executeCommentCommand(agent, command, "toboto")
+	if notifier == nil || notifier.JenkinsProject.Name != "dev-jenkins-project" ||
+		notifier.Branch != "dosomething" || notifier.Sha != "00097bcf95d6282443074c51791392a3f9a3909d" {
+		t.Fatalf("Deploy command should deploy the pull request head, reply %s", reply)
+	}
+	if deployAgent.Environment() != "debug" || !strings.Contains(reply, "Deploying `dosomething` to debug") {
+		t.Errorf("Deploy command reply error, actual %s", reply)
+	}
+
+	command, _ = parseCommentCommand("/deploy production")
+	if reply, _, notifier := executeCommentCommand(agent, command, "stranger"); notifier != nil ||
+		!strings.Contains(reply, "not allowed") {
+		t.Errorf("Deploy by stranger should be rejected, actual %s", reply)
+	}
+	command, _ = parseCommentCommand("/deploy")
+	if reply, _, notifier := executeCommentCommand(agent, command, "toboto"); notifier != nil ||
+		!strings.Contains(reply, "Usage") {
+		t.Errorf("Deploy without environment should reply usage, actual %s", reply)
+	}
+	command, _ = parseCommentCommand("/redeploy")
+	if reply, _, notifier := executeCommentCommand(agent, command, "toboto"); notifier != nil ||
+		!strings.Contains(reply, "Only merged") {
+		t.Errorf("Redeploy of open pull request should be rejected, actual %s", reply)
+	}
+	agent.noteHook.PullRequest.Merged = true
+	if reply, _, notifier := executeCommentCommand(agent, command, "toboto"); notifier == nil ||
+		notifier.Branch != "develop" || notifier.Sha != "0899444d680c13ba2122f208f59f5f64517f480b" {
+		t.Errorf("Redeploy of merged pull request should deploy the base branch, actual %s", reply)
+	}
+	command, _ = parseCommentCommand("/cancel")
+	if reply, _, _ := executeCommentCommand(agent, command, "toboto"); !strings.Contains(reply, "No active deploy") {
+		t.Errorf("Cancel without deploy should reply no deploy, actual %s", reply)
+	}
+}
+
+func TestHandleCommentCommand(t *testing.T) {
+	var replies []string
+	gitee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/repos/toboto/mingdao/pulls/9/comments" || body["access_token"] != "gitee-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		replies = append(replies, body["body"])
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer gitee.Close()
+	settings.giteeApiUrl, settings.giteeToken = gitee.URL, "gitee-token"
+	defer func() { settings.giteeToken, settings.webhookSecret = "", "" }()
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	loadCommandConfig("config/commands.sample.yaml")
+	defer loadCommandConfig("")
+
+	handleCommentCommand(context.Background(), logger, BasicHook{}, loadNoteHookAgent(t, "/deploy production"))
+	if len(replies) != 0 {
+		t.Errorf("Commands should be refused without a webhook secret, actual %v", replies)
+	}
+	settings.webhookSecret = "hook-key"
+	handleCommentCommand(context.Background(), logger, BasicHook{}, loadNoteHookAgent(t, "LGTM"))
+	handleCommentCommand(context.Background(), logger, BasicHook{}, loadNoteHookAgent(t, "/deploy production"))
+	if len(replies) != 1 || !strings.Contains(replies[0], "environment=production") {
+		t.Errorf("Command should be acknowledged by a comment, actual %v", replies)
+	}
+}
+
+func TestReplyGiteeComment_Timeout(t *testing.T) {
+	timeout := vcsApiClient.Timeout
+	defer func() { vcsApiClient.Timeout, settings.giteeToken = timeout, "" }()
+	vcsApiClient.Timeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	gitee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer gitee.Close()
+	defer close(release)
+	settings.giteeApiUrl, settings.giteeToken = gitee.URL, "gitee-token"
+	if err := replyGiteeComment("toboto/mingdao", 9, "ok"); err == nil {
+		t.Error("A hanging Gitee API should time out")
+	}
+}
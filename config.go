@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Sources of a server setting, from the highest precedence to the lowest.
+const (
+	SettingFromFlag    = "flag"
+	SettingFromEnv     = "env"
+	SettingFromFile    = "file"
+	SettingFromDefault = "default"
+)
+
+// settingEnvPrefix prefixes the environment variable of every server setting, e.g.
+// PRCD_JENKINS_API_TOKEN for -jenkins-api-token.
+const settingEnvPrefix = "PRCD_"
+
+// settingAliases are short flags sharing the setting of a long one. Only the long name is read from
+// the environment and the config file.
+var settingAliases = map[string]string{"h": "host", "p": "port"}
+
+// secretSettings are masked by -print-config.
+var secretSettings = map[string]bool{"jenkins-api-token": true, "github-token": true, "gitee-token": true, "admin-token": true,
+	"vault-token": true, "webhook-secret": true}
+
+// settingEnvName returns the environment variable of a setting.
+func settingEnvName(name string) string {
+	return settingEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applySettingSources fills the flags not given on the command line from the environment, then
+// from the YAML server config file named by the config flag. Keys of the file are the flag names,
+// with "-" or "_". It returns where each setting comes from.
+func applySettingSources(flags *flag.FlagSet, lookupEnv func(string) (string, bool)) (map[string]string, error) {
+	sources := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = SettingFromDefault
+	})
+	flags.Visit(func(f *flag.Flag) {
+		sources[f.Name] = SettingFromFlag
+		if name, ok := settingAliases[f.Name]; ok {
+			sources[name] = SettingFromFlag
+		}
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, alias := settingAliases[f.Name]; alias || sources[f.Name] != SettingFromDefault || err != nil {
+			return
+		}
+		if value, ok := lookupEnv(settingEnvName(f.Name)); ok {
+			if e := flags.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid %s: %v", settingEnvName(f.Name), e)
+			}
+			sources[f.Name] = SettingFromEnv
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filename := flags.Lookup("config").Value.String()
+	if filename == "" {
+		return sources, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("load server config: %v", err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, fmt.Errorf("load server config %s: %v", filename, err)
+	}
+	for key, value := range values {
+		name := strings.Replace(key, "_", "-", -1)
+		f := flags.Lookup(name)
+		if _, alias := settingAliases[name]; f == nil || alias || name == "config" || name == "print-config" {
+			return nil, fmt.Errorf("load server config %s: unknown setting %s", filename, key)
+		}
+		if sources[name] != SettingFromDefault || value == nil {
+			continue
+		}
+		if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+			return nil, fmt.Errorf("load server config %s: invalid %s: %v", filename, key, err)
+		}
+		sources[name] = SettingFromFile
+	}
+	return sources, nil
+}
+
+// printSettings writes the effective settings and their sources, secrets are masked.
+func printSettings(w io.Writer, flags *flag.FlagSet, sources map[string]string) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, alias := settingAliases[f.Name]; alias || f.Name == "print-config" {
+			return
+		}
+		value := f.Value.String()
+		if secretSettings[f.Name] && value != "" {
+			value = redactedValue
+		}
+		fmt.Fprintf(w, "%s: %q # %s\n", strings.Replace(f.Name, "-", "_", -1), value, sources[f.Name])
+	})
+}
+
+// resolveSecretSettings replaces the secret references of secret settings with the secrets. The
+// Vault token is resolved first, as the other references may need it.
+func resolveSecretSettings(flags *flag.FlagSet) error {
+	var names []string
+	for name := range secretSettings {
+		if name != "vault-token" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range append([]string{"vault-token"}, names...) {
+		f := flags.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if err := checkSecretReference(f.Value.String()); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		value, err := resolveSecret(f.Value.String())
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		flags.Set(name, value)
+	}
+	return nil
+}
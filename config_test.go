@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSettings struct {
+	configFile, host, jenkinsHost, jenkinsApiToken string
+	port                                           int64
+	verbose                                        bool
+	interval                                       time.Duration
+}
+
+func newTestSettingFlags(s *testSettings) *flag.FlagSet {
+	flags := flag.NewFlagSet("prcd", flag.ContinueOnError)
+	flags.StringVar(&s.configFile, "config", "", "")
+	flags.StringVar(&s.host, "h", "", "")
+	flags.StringVar(&s.host, "host", "", "")
+	flags.Int64Var(&s.port, "p", 8889, "")
+	flags.Int64Var(&s.port, "port", 8889, "")
+	flags.StringVar(&s.jenkinsHost, "jenkins-host", "http://cd.mimixiche.cn", "")
+	flags.StringVar(&s.jenkinsApiToken, "jenkins-api-token", "", "")
+	flags.BoolVar(&s.verbose, "verbose", false, "")
+	flags.DurationVar(&s.interval, "log-rotate-interval", 0, "")
+	return flags
+}
+
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestApplySettingSources_Precedence(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "prcd.yaml")
+	ioutil.WriteFile(config, []byte(`
+host: 10.0.0.1
+port: 9000
+jenkins_host: http://file-jenkins
+jenkins-api-token: file-token
+verbose: true
+log_rotate_interval: 24h
+`), 0640)
+	s := testSettings{}
+	flags := newTestSettingFlags(&s)
+	flags.Parse([]string{"-config", config, "-p", "8000"})
+	sources, err := applySettingSources(flags, testEnv(map[string]string{
+		"PRCD_PORT":              "7000",
+		"PRCD_JENKINS_HOST":      "http://env-jenkins",
+		"PRCD_JENKINS_API_TOKEN": "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.port != 8000 || sources["port"] != SettingFromFlag {
+		t.Errorf("Flags should take precedence, actual port %d from %s", s.port, sources["port"])
+	}
+	if s.jenkinsHost != "http://env-jenkins" || sources["jenkins-host"] != SettingFromEnv {
+		t.Errorf("Environment variables should take precedence over the file, actual %s", s.jenkinsHost)
+	}
+	if s.jenkinsApiToken != "" || sources["jenkins-api-token"] != SettingFromEnv {
+		t.Errorf("An empty environment variable should be used, actual %q", s.jenkinsApiToken)
+	}
+	if s.host != "10.0.0.1" || !s.verbose || s.interval != 24*time.Hour || sources["host"] != SettingFromFile {
+		t.Errorf("The file should fill the other settings, actual %+v", s)
+	}
+}
+
+func TestApplySettingSources_Sample(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	flags := flag.NewFlagSet("prcd", flag.ContinueOnError)
+	registerSettingFlags(flags)
+	flags.Parse([]string{"-config", "config/prcd.sample.yaml"})
+	if _, err := applySettingSources(flags, testEnv(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if settings.jenkinsUserName != "akimimi" || settings.logRotateInterval != 24*time.Hour || settings.logFormat != LogFormatJson {
+		t.Errorf("The sample server config should be loaded, actual %+v", settings)
+	}
+}
+
+func TestApplySettingSources_ConfigFromEnv(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "prcd.yaml")
+	ioutil.WriteFile(config, []byte("jenkins_host: http://file-jenkins\n"), 0640)
+	s := testSettings{}
+	flags := newTestSettingFlags(&s)
+	flags.Parse(nil)
+	sources, err := applySettingSources(flags, testEnv(map[string]string{"PRCD_CONFIG": config}))
+	if err != nil || s.jenkinsHost != "http://file-jenkins" {
+		t.Errorf("The config file should be named by PRCD_CONFIG, actual %s, %v", s.jenkinsHost, err)
+	}
+	if sources["verbose"] != SettingFromDefault {
+		t.Errorf("Settings from no source should be defaults, actual %s", sources["verbose"])
+	}
+}
+
+func TestApplySettingSources_Invalid(t *testing.T) {
+	testData := map[string]string{
+		"jenkins_hots: http://file-jenkins\n": "unknown setting jenkins_hots",
+		"p: 9000\n":                           "unknown setting p",
+		"port: ninety\n":                      "invalid port",
+		"port: [\n":                           "load server config",
+	}
+	for content, expected := range testData {
+		config := filepath.Join(t.TempDir(), "prcd.yaml")
+		ioutil.WriteFile(config, []byte(content), 0640)
+		s := testSettings{}
+		flags := newTestSettingFlags(&s)
+		flags.Parse([]string{"-config", config})
+		if _, err := applySettingSources(flags, testEnv(nil)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Config %q should fail with %q, actual %v", content, expected, err)
+		}
+	}
+
+	s := testSettings{}
+	flags := newTestSettingFlags(&s)
+	flags.Parse(nil)
+	if _, err := applySettingSources(flags, testEnv(map[string]string{"PRCD_VERBOSE": "yes please"})); err == nil ||
+		!strings.Contains(err.Error(), "PRCD_VERBOSE") {
+		t.Errorf("An invalid environment variable should fail, actual %v", err)
+	}
+}
+
+func TestPrintSettings(t *testing.T) {
+	s := testSettings{}
+	flags := newTestSettingFlags(&s)
+	flags.Parse([]string{"-jenkins-api-token", "s3cr3t-token", "-p", "8000"})
+	sources, _ := applySettingSources(flags, testEnv(nil))
+	var buf bytes.Buffer
+	printSettings(&buf, flags, sources)
+	output := buf.String()
+	if strings.Contains(output, "s3cr3t-token") || !strings.Contains(output, `jenkins_api_token: "******" # flag`) {
+		t.Errorf("Secrets should be masked, actual %s", output)
+	}
+	if !strings.Contains(output, `port: "8000" # flag`) || strings.Contains(output, "\np:") {
+		t.Errorf("Aliases should be printed under the long name, actual %s", output)
+	}
+	if !strings.Contains(output, `jenkins_host: "http://cd.mimixiche.cn" # default`) {
+		t.Errorf("Defaults should be printed, actual %s", output)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// recordFeed fans out deployment records to the connected dashboards as they are saved.
+type recordFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan DeploymentRecord]bool
+}
+
+var dashboardFeed = &recordFeed{subscribers: make(map[chan DeploymentRecord]bool)}
+
+func (feed *recordFeed) subscribe() chan DeploymentRecord {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	ch := make(chan DeploymentRecord, 64)
+	feed.subscribers[ch] = true
+	return ch
+}
+
+func (feed *recordFeed) unsubscribe(ch chan DeploymentRecord) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	delete(feed.subscribers, ch)
+}
+
+// publish sends a record to every subscriber, a subscriber that is not keeping up misses it.
+func (feed *recordFeed) publish(record DeploymentRecord) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	for ch := range feed.subscribers {
+		select {
+		case ch <- record:
+		default:
+		}
+	}
+}
+
+// createDashboardServer returns the server of the dashboard and its APIs on
+// settings.dashboardListen, or nil if the address is empty. They have no authentication and show
+// the deployments and the projects, so they are kept off the public hook port.
+func createDashboardServer() *http.Server {
+	if settings.dashboardListen == "" {
+		return nil
+	}
+	r := createGinEngine()
+	registerDashboard(r)
+	return &http.Server{Addr: settings.dashboardListen, Handler: r}
+}
+
+// registerDashboard serves the dashboard under settings.dashboardUrl and the APIs it reads.
+func registerDashboard(r *gin.Engine) {
+	assets, _ := fs.Sub(dashboardAssets, "dashboard")
+	r.StaticFS(settings.dashboardUrl, http.FS(assets))
+	r.GET("/api/deployments", onListDeployments)
+	r.GET("/api/deployments/last", onListLastDeploys)
+	r.GET("/api/deployments/stream", onStreamDeployments)
+	r.GET("/api/projects", onListProjects)
+}
+
+// onListProjects serves the projects.yaml entries, tokens and credentials are left out.
+func onListProjects(c *gin.Context) {
+	grp := jenkinsProjectConfigs()
+	names := make([]string, 0, len(grp))
+	for name := range grp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	projects := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		config := grp[name]
+		server := createNotifier(config.jenkinsProject()).server()
+		projects = append(projects, gin.H{
+			"name":            name,
+			"source":          config.Source,
+			"environment":     config.Environment,
+			"vcs_project":     config.VcsProject,
+			"branch":          config.Branch,
+			"jenkins_project": config.JenkinsProject,
+			"jenkins_server":  config.JenkinsServer,
+			"jenkins_host":    server.Host,
+			"jenkins_url":     server.Url,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"projects": projects})
+}
+
+// onListLastDeploys serves the last deploy of each project and environment.
+func onListLastDeploys(c *gin.Context) {
+	if historyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"errmsg": "deployment history is disabled"})
+		return
+	}
+	records, err := historyStore.LastDeploys()
+	if err != nil {
+		logger.Error("query last deploys failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errmsg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deployments": records})
+}
+
+// onStreamDeployments streams deployment records as server-sent events while they are saved.
+func onStreamDeployments(c *gin.Context) {
+	if historyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"errmsg": "deployment history is disabled"})
+		return
+	}
+	ch := dashboardFeed.subscribe()
+	defer dashboardFeed.unsubscribe(ch)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Type", "text/event-stream")
+	// Send the headers now, the client waits for them before any record is saved.
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case record := <-ch:
+			c.SSEvent("deployment", record)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,174 @@
+(function () {
+  "use strict";
+
+  var maxHooks = 200;
+  var hookRows = {};
+
+  function cell(text, className) {
+    var td = document.createElement("td");
+    if (className) {
+      td.className = className;
+    }
+    td.textContent = text == null ? "" : String(text);
+    return td;
+  }
+
+  function statusCell(status) {
+    var td = document.createElement("td");
+    var span = document.createElement("span");
+    span.className = "status " + status;
+    span.textContent = status;
+    td.appendChild(span);
+    return td;
+  }
+
+  // Urls come from hook payloads, only http and https ones are linked.
+  function safeUrl(url) {
+    return /^https?:\/\//i.test(url || "") ? url : "";
+  }
+
+  function linkCell(text, url) {
+    var td = document.createElement("td");
+    url = safeUrl(url);
+    if (url) {
+      var a = document.createElement("a");
+      a.href = url;
+      a.target = "_blank";
+      a.textContent = text;
+      td.appendChild(a);
+    } else {
+      td.textContent = text || "";
+    }
+    return td;
+  }
+
+  function time(value) {
+    return value ? new Date(value).toLocaleString() : "";
+  }
+
+  function row(cells) {
+    var tr = document.createElement("tr");
+    cells.forEach(function (td) { tr.appendChild(td); });
+    return tr;
+  }
+
+  function hookRow(d) {
+    var jenkins = (d.jenkins_projects || []).join(", ");
+    if (d.result) {
+      jenkins += " (" + d.result + ")";
+    }
+    if (d.dry_run) {
+      jenkins += " [dry run]";
+    }
+    if (d.folded) {
+      jenkins += " [" + d.folded.length + " folded]";
+    }
+    var tr = row([
+      cell(time(d.received_at)),
+      cell(d.hook_name),
+      cell(d.project),
+      cell(d.branch),
+      cell(d.environment),
+      statusCell(d.status),
+      cell(d.match_reason || d.error, "reason"),
+      linkCell(jenkins, d.build_url),
+      cell(d.correlation_id)
+    ]);
+    return tr;
+  }
+
+  function showHook(d, live) {
+    var body = document.querySelector("#hooks tbody");
+    var tr = hookRow(d);
+    if (live) {
+      tr.className = "new";
+    }
+    var old = hookRows[d.id];
+    if (old) {
+      body.replaceChild(tr, old);
+    } else if (live) {
+      body.insertBefore(tr, body.firstChild);
+    } else {
+      body.appendChild(tr);
+    }
+    hookRows[d.id] = tr;
+    while (body.children.length > maxHooks) {
+      body.removeChild(body.lastChild);
+    }
+  }
+
+  function get(url) {
+    return fetch(url).then(function (resp) {
+      return resp.json().then(function (body) {
+        if (!resp.ok) {
+          throw new Error(body.errmsg || resp.statusText);
+        }
+        return body;
+      });
+    });
+  }
+
+  function showError(table, err) {
+    var body = document.querySelector(table + " tbody");
+    var td = cell(err.message, "reason");
+    td.colSpan = document.querySelectorAll(table + " th").length;
+    body.innerHTML = "";
+    body.appendChild(row([td]));
+  }
+
+  function loadProjects() {
+    get("/api/projects").then(function (body) {
+      var tbody = document.querySelector("#projects tbody");
+      tbody.innerHTML = "";
+      body.projects.forEach(function (p) {
+        tbody.appendChild(row([
+          cell(p.name), cell(p.environment), cell(p.vcs_project), cell(p.branch),
+          cell(p.jenkins_project), cell(p.jenkins_host), cell(p.jenkins_url)
+        ]));
+      });
+    }).catch(function (err) { showError("#projects", err); });
+  }
+
+  function loadLastDeploys() {
+    get("/api/deployments/last").then(function (body) {
+      var tbody = document.querySelector("#last-deploys tbody");
+      tbody.innerHTML = "";
+      body.deployments.forEach(function (d) {
+        tbody.appendChild(row([
+          cell(d.project), cell(d.environment), cell(d.branch),
+          linkCell((d.jenkins_projects || []).join(", "), d.build_url),
+          statusCell(d.status), cell(d.result || d.error), cell(time(d.notified_at)),
+          linkCell(d.title, d.url)
+        ]));
+      });
+    }).catch(function (err) { showError("#last-deploys", err); });
+  }
+
+  function loadHooks() {
+    return get("/api/deployments?per_page=100").then(function (body) {
+      body.deployments.forEach(function (d) { showHook(d, false); });
+    }).catch(function (err) { showError("#hooks", err); });
+  }
+
+  function stream() {
+    var state = document.getElementById("stream-state");
+    var source = new EventSource("/api/deployments/stream");
+    source.onopen = function () {
+      state.textContent = "live";
+      state.className = "state live";
+    };
+    source.onerror = function () {
+      state.textContent = "reconnecting";
+      state.className = "state down";
+    };
+    source.addEventListener("deployment", function (e) {
+      showHook(JSON.parse(e.data), true);
+      loadLastDeploys();
+    });
+  }
+
+  loadProjects();
+  loadLastDeploys();
+  loadHooks().then(stream);
+  setInterval(loadProjects, 60000);
+})();
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createDashboardEngine() http.Handler {
+	settings.dashboardUrl = "/dashboard"
+	r := createGinEngine()
+	registerDashboard(r)
+	return r
+}
+
+func TestDashboard_Assets(t *testing.T) {
+	r := createDashboardEngine()
+	for path, expected := range map[string]string{
+		"/dashboard/":       "<title>prcd dashboard</title>",
+		"/dashboard/app.js": "/api/deployments/stream",
+	} {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), expected) {
+			t.Errorf("%s should be served from the embedded assets, actual %d", path, resp.Code)
+		}
+	}
+}
+
+func TestOnListProjects(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	r := createDashboardEngine()
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/api/projects", nil))
+	if strings.Contains(resp.Body.String(), "abcdefg1234") {
+		t.Errorf("Projects should not expose jenkins tokens, actual %s", resp.Body.String())
+	}
+	body := struct{ Projects []map[string]string }{}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if len(body.Projects) != len(jenkinsProjectConfigGrp) || body.Projects[0]["name"] == "" {
+		t.Errorf("Projects should list every entry, actual %s", resp.Body.String())
+	}
+}
+
+func TestHistoryStore_LastDeploys(t *testing.T) {
+	store := openTestHistoryStore(t)
+	notified := time.Now()
+	for _, r := range []DeploymentRecord{
+		{Project: "toboto/mingdao", Environment: "production", Status: DeploySucceeded, NotifiedAt: &notified, Sha: "old"},
+		{Project: "toboto/mingdao", Environment: "production", Status: DeployFailed, Sha: "new"},
+		{Project: "toboto/mingdao", Environment: "production", Status: HistoryUnmatched},
+		{Project: "toboto/mingdao", Environment: "debug", Status: DeployTriggered, NotifiedAt: &notified},
+		{Project: "akimimi/prcd", Environment: "debug", Status: HistoryIgnored},
+	} {
+		r.ReceivedAt = time.Now()
+		store.Save(&r)
+	}
+	records, err := store.LastDeploys()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Last deploys should hold one dispatched record per project and environment, actual %v %v", records, err)
+	}
+	if records[0].Environment != "debug" || records[1].Environment != "production" || records[1].Sha != "new" {
+		t.Errorf("Last deploys should be the newest records sorted by environment, actual %v", records)
+	}
+}
+
+func TestOnStreamDeployments(t *testing.T) {
+	historyStore = openTestHistoryStore(t)
+	defer func() { historyStore = nil }()
+	ts := httptest.NewServer(createDashboardEngine())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/deployments/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	saveDeploymentRecord(logger, &DeploymentRecord{CorrelationId: "delivery-1", Status: HistoryUnmatched,
+		MatchReason: "no entry for vcs_project toboto/mingdao", ReceivedAt: time.Now()})
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			record := DeploymentRecord{}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &record)
+			if record.CorrelationId != "delivery-1" || record.MatchReason == "" {
+				t.Errorf("The saved record should be streamed, actual %s", line)
+			}
+			return
+		}
+	}
+	t.Error("No record streamed")
+}
+
+func TestUnmatchedReason(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	testData := map[[3]string]string{
+		{"debug", "unknown", "develop"}:             "no entry for vcs_project unknown",
+		{"staging", "mimixiche-backend", "develop"}: "no entry for vcs_project mimixiche-backend in environment staging",
+		{"debug", "mimixiche-backend", "feature"}:   "no entry for branch feature of vcs_project mimixiche-backend in environment debug",
+	}
+	for hook, expected := range testData {
+		if reason := unmatchedReason(hook[0], hook[1], hook[2]); reason != expected {
+			t.Errorf("Unmatched reason of %v, expected %s, actual %s", hook, expected, reason)
+		}
+	}
+}
+
+func TestCreateDashboardServer(t *testing.T) {
+	defer func() { settings.dashboardListen = "" }()
+	settings.dashboardListen = ""
+	if createDashboardServer() != nil {
+		t.Error("The dashboard should be disabled without a listen address")
+	}
+	settings.dashboardListen, settings.dashboardUrl = "127.0.0.1:8890", "/dashboard"
+	srv := createDashboardServer()
+	if srv == nil || srv.Addr != "127.0.0.1:8890" {
+		t.Fatalf("The dashboard should listen on its own address, actual %+v", srv)
+	}
+	for path, code := range map[string]int{"/dashboard/": http.StatusOK, "/api/projects": http.StatusOK, "/notify": http.StatusNotFound} {
+		resp := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+		if resp.Code != code {
+			t.Errorf("%s should be answered with %d by the dashboard server, actual %d", path, code, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncedDeploy is a deploy waiting for its Jenkins job to be quiet. A trigger of the same job
+// during the wait replaces its hook and pushes the deadline back, the replaced triggers are folded
+// into the deploy.
+type debouncedDeploy struct {
+	agent    HookAgent
+	notifier *JenkinsNotifier
+	folded   []string
+	deadline time.Time
+}
+
+// debouncedDeploys are the waiting deploys by Jenkins job.
+var debouncedDeploys = struct {
+	sync.Mutex
+	m map[string]*debouncedDeploy
+}{m: make(map[string]*debouncedDeploy)}
+
+// clock tells the time to the debounced deploys, the tests replace it to drive the quiet periods.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var debounceClock clock = realClock{}
+
+// debounceKey identifies the Jenkins job of a deploy.
+func debounceKey(notifier *JenkinsNotifier) string {
+	return notifier.server().Host + "/" + notifier.JenkinsProject.Name
+}
+
+// debounceDeploy dispatches a deploy once its Jenkins job has had no other trigger for the
+// debounce period of the project, with the hook of the last trigger. It returns at once if a
+// deploy of the job is already waiting, the trigger then replaces its hook. It is called in the
+// dispatch goroutine of the hook, the first trigger's goroutine does the waiting. When prcd
+// shuts down, the waiting deploys are dispatched at once and new ones are not debounced.
+func debounceDeploy(agent HookAgent, notifier *JenkinsNotifier) {
+	log, key, quiet := notifier.logger(), debounceKey(notifier), notifier.JenkinsProject.Debounce
+	debouncedDeploys.Lock()
+	if isDraining() {
+		debouncedDeploys.Unlock()
+		event := newDeployEvent(agent, notifier)
+		followDeploy(notifier, createDeployReporters(agent, &event), &event)
+		return
+	}
+	if d, ok := debouncedDeploys.m[key]; ok {
+		replaced := d.notifier.CorrelationId
+		d.folded = append(d.folded, replaced)
+		d.agent, d.notifier, d.deadline = agent, notifier, debounceClock.Now().Add(quiet)
+		debouncedDeploys.Unlock()
+		log.Info("deploy debounced, replacing the waiting trigger", "jenkins_project", notifier.JenkinsProject.Name,
+			"replaced", replaced, "debounce", quiet.String())
+		return
+	}
+	d := &debouncedDeploy{agent: agent, notifier: notifier, deadline: debounceClock.Now().Add(quiet)}
+	debouncedDeploys.m[key] = d
+	debouncedDeploys.Unlock()
+	log.Info("deploy debounced", "jenkins_project", notifier.JenkinsProject.Name, "debounce", quiet.String())
+
+	for wait := quiet; ; {
+		select {
+		case <-debounceClock.After(wait):
+		case <-draining:
+		}
+		debouncedDeploys.Lock()
+		if wait = d.deadline.Sub(debounceClock.Now()); wait > 0 && !isDraining() {
+			debouncedDeploys.Unlock()
+			continue
+		}
+		delete(debouncedDeploys.m, key)
+		debouncedDeploys.Unlock()
+		break
+	}
+
+	event := newDeployEvent(d.agent, d.notifier)
+	event.Folded = d.folded
+	if len(d.folded) > 0 {
+		event.logger().Info("debounced triggers folded into the deploy", "jenkins_project", event.JenkinsProject,
+			"folded", d.folded)
+		markFoldedDeploys(event)
+	}
+	followDeploy(d.notifier, createDeployReporters(d.agent, &event), &event)
+}
+
+// markFoldedDeploys records in the history that the folded triggers of a deploy went into it.
+func markFoldedDeploys(event DeployEvent) {
+	if historyStore == nil {
+		return
+	}
+	for _, correlationId := range event.Folded {
+		var updated DeploymentRecord
+		err := historyStore.UpdateByCorrelationId(correlationId, func(record *DeploymentRecord) {
+			record.Status, record.FoldedInto = HistoryFolded, event.CorrelationId
+			updated = *record
+		})
+		if err != nil {
+			Logger{CorrelationId: correlationId}.Error("save deployment record failed", "status", HistoryFolded, "error", err)
+			continue
+		}
+		dashboardFeed.publish(updated)
+	}
+}
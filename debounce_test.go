@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the tests. waiting receives a value each time a debounced
+// deploy starts to wait.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	waiting chan struct{}
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), waiting: make(chan struct{}, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	c.waiting <- struct{}{}
+	return timer.c
+}
+
+// Advance moves the clock forward and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// useFakeClock makes the debounced deploys use a fake clock, and undoes it and any shutdown
+// drain when the test ends.
+func useFakeClock(t *testing.T) *fakeClock {
+	clock := newFakeClock()
+	debounceClock = clock
+	t.Cleanup(func() {
+		debounceClock = realClock{}
+		debouncedDeploys.Lock()
+		debouncedDeploys.m = make(map[string]*debouncedDeploy)
+		debouncedDeploys.Unlock()
+		draining, drainOnce = make(chan struct{}), sync.Once{}
+	})
+	return clock
+}
+
+func newDebounceTestAgent(t *testing.T) HookAgent {
+	agent := &GithubPullRequestHookAgent{}
+	b, err := ioutil.ReadFile("samples/github_pull_request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestDebounceDeploy(t *testing.T) {
+	clock := useFakeClock(t)
+	var mu sync.Mutex
+	var notified []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		notified = append(notified, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	agent := newDebounceTestAgent(t)
+	newNotifier := func(id string) *JenkinsNotifier {
+		return &JenkinsNotifier{
+			JenkinsHost:    ts.URL,
+			JenkinsUrl:     "/job/<project>/build?token=<token>",
+			JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234", Debounce: 100 * time.Millisecond},
+			CorrelationId:  id,
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		dispatchDeploy(agent, newNotifier("delivery-1"))
+		close(done)
+	}()
+	<-clock.waiting
+	clock.Advance(40 * time.Millisecond)
+	dispatchDeploy(agent, newNotifier("delivery-2"))
+	clock.Advance(40 * time.Millisecond)
+	dispatchDeploy(agent, newNotifier("delivery-3"))
+
+	// The first quiet period ends, the deploy waits for the one of delivery-3.
+	clock.Advance(20 * time.Millisecond)
+	<-clock.waiting
+	mu.Lock()
+	if len(notified) != 0 {
+		t.Errorf("Jenkins should not be notified during the debounce period, actual %v", notified)
+	}
+	mu.Unlock()
+	clock.Advance(80 * time.Millisecond)
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 1 || notified[0] != "/job/pro/build" {
+		t.Errorf("Triggers of a job should be folded into one notify, actual %v", notified)
+	}
+	if len(debouncedDeploys.m) != 0 {
+		t.Errorf("The deploy should not wait after the notify, actual %v", debouncedDeploys.m)
+	}
+}
+
+func TestDebounceDeploy_History(t *testing.T) {
+	clock := useFakeClock(t)
+	historyStore = openTestHistoryStore(t)
+	defer func() { historyStore = nil }()
+
+	agent := newDebounceTestAgent(t)
+	ids := []string{"delivery-1", "delivery-2", "delivery-3"}
+	done := make(chan struct{})
+	for i, id := range ids {
+		historyStore.Save(&DeploymentRecord{CorrelationId: id, Status: HistoryMatched})
+		notifier := &JenkinsNotifier{
+			JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234", Debounce: 50 * time.Millisecond},
+			CorrelationId:  id,
+			DryRun:         true,
+		}
+		if i == 0 {
+			go func() {
+				dispatchDeploy(agent, notifier)
+				close(done)
+			}()
+			<-clock.waiting
+		} else {
+			dispatchDeploy(agent, notifier)
+		}
+	}
+	clock.Advance(50 * time.Millisecond)
+	<-done
+
+	records, _, _ := historyStore.Query(DeploymentFilter{})
+	byId := make(map[string]DeploymentRecord)
+	for _, record := range records {
+		byId[record.CorrelationId] = record
+	}
+	for _, id := range ids[:2] {
+		if record := byId[id]; record.Status != HistoryFolded || record.FoldedInto != "delivery-3" {
+			t.Errorf("Replaced trigger %s should be folded into delivery-3, actual %+v", id, record)
+		}
+	}
+	if record := byId["delivery-3"]; record.Status != DeployTriggered || !reflect.DeepEqual(record.Folded, ids[:2]) {
+		t.Errorf("The deploy should record the folded triggers, actual %+v", record)
+	}
+}
+
+func TestDrainDispatches(t *testing.T) {
+	clock := useFakeClock(t)
+	release := make(chan struct{})
+	requests := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/job/slow/build" {
+			<-release
+		}
+		requests <- r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	agent := newDebounceTestAgent(t)
+	newNotifier := func(name string, debounce time.Duration) *JenkinsNotifier {
+		return &JenkinsNotifier{
+			JenkinsHost:    ts.URL,
+			JenkinsUrl:     "/job/<project>/build?token=<token>",
+			JenkinsProject: JenkinsProject{Name: name, Token: "abcd1234", Debounce: debounce},
+		}
+	}
+	for _, notifier := range []*JenkinsNotifier{newNotifier("pro", time.Hour), newNotifier("slow", 0)} {
+		notifier := notifier
+		hookDispatches.Add(1)
+		go func() {
+			defer hookDispatches.Done()
+			dispatchDeploy(agent, notifier)
+		}()
+	}
+	<-clock.waiting
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if drainDispatches(ctx) {
+		t.Error("Draining should give up when its context is done before the dispatches")
+	}
+	close(release)
+	if !drainDispatches(context.Background()) {
+		t.Fatal("Draining should wait until the dispatches are done")
+	}
+	notified := map[string]bool{}
+	for len(requests) > 0 {
+		notified[<-requests] = true
+	}
+	if !notified["/job/pro/build"] || !notified["/job/slow/build"] {
+		t.Errorf("The waiting deploy and the running dispatch should notify Jenkins, actual %v", notified)
+	}
+
+	dispatchDeploy(agent, newNotifier("pro", time.Hour))
+	select {
+	case <-requests:
+	default:
+		t.Error("Deploys dispatched while draining should not be debounced")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Deploy statuses reported while a matched hook is dispatched to Jenkins.
+const (
+	DeployTriggered = "triggered"
+	DeployStarted   = "started"
+	DeploySucceeded = "succeeded"
+	DeployFailed    = "failed"
+)
+
+// DeployEvent describes the progress of a deploy, it carries the hook and Jenkins fields used by
+// deployment feedback and notification templates.
+type DeployEvent struct {
+	CorrelationId string
+
+	Status      string
+	Agent       string
+	Project     string
+	Branch      string
+	Environment string
+
+	// Pull request fields, empty if the hook is not a pull request.
+	Number int
+	Title  string
+	Body   string
+	Url    string
+	Sender string
+	Sha    string
+
+	// Comment command that requested the deploy and its author, empty for merged pull requests.
+	Command     string
+	RequestedBy string
+
+	JenkinsProject string
+	// DryRun is true if Jenkins is not notified, see JenkinsNotifier.DryRun.
+	DryRun   bool
+	QueueUrl string
+	BuildUrl string
+	Result   string
+	Error    string
+	Time     time.Time
+
+	// Folded are the correlation ids of the earlier triggers replaced by this one while the
+	// deploy was debounced.
+	Folded []string
+}
+
+// activeDeploy is a notified deploy of a pull request, kept for cancelling.
+type activeDeploy struct {
+	notifier    *JenkinsNotifier
+	environment string
+	notifiedAt  time.Time
+}
+
+var (
+	activeDeploys   = make(map[string]activeDeploy)
+	activeDeploysMu sync.Mutex
+)
+
+func activeDeployKey(project string, number int) string {
+	return project + "#" + strconv.Itoa(number)
+}
+
+// trackActiveDeploy remembers the latest deploy of a pull request, deploys older than the build
+// timeout are dropped.
+func trackActiveDeploy(event *DeployEvent, notifier *JenkinsNotifier) {
+	if event.Number == 0 {
+		return
+	}
+	now := time.Now()
+	timeout := time.Duration(settings.jenkinsBuildTimeout) * time.Second
+
+	activeDeploysMu.Lock()
+	defer activeDeploysMu.Unlock()
+	for k, d := range activeDeploys {
+		if now.Sub(d.notifiedAt) > timeout {
+			delete(activeDeploys, k)
+		}
+	}
+	activeDeploys[activeDeployKey(event.Project, event.Number)] = activeDeploy{notifier, event.Environment, now}
+}
+
+func untrackActiveDeploy(event *DeployEvent, notifier *JenkinsNotifier) {
+	activeDeploysMu.Lock()
+	defer activeDeploysMu.Unlock()
+	key := activeDeployKey(event.Project, event.Number)
+	if d, ok := activeDeploys[key]; ok && d.notifier == notifier {
+		delete(activeDeploys, key)
+	}
+}
+
+func findActiveDeploy(project string, number int) (activeDeploy, bool) {
+	activeDeploysMu.Lock()
+	defer activeDeploysMu.Unlock()
+	d, ok := activeDeploys[activeDeployKey(project, number)]
+	return d, ok
+}
+
+// DeployReporter receives every status change of a deploy. Reporters are called in the dispatch
+// goroutine, they must handle their own failures and never stop the deploy.
+type DeployReporter interface {
+	Report(event DeployEvent)
+}
+
+func (event *DeployEvent) logger() Logger {
+	return Logger{CorrelationId: event.CorrelationId}
+}
+
+func newDeployEvent(agent HookAgent, notifier *JenkinsNotifier) DeployEvent {
+	event := DeployEvent{
+		CorrelationId:  notifier.CorrelationId,
+		Agent:          agent.Name(),
+		Project:        agent.HookProject(),
+		Branch:         agent.HookBranch(),
+		Environment:    agent.Environment(),
+		JenkinsProject: notifier.JenkinsProject.Name,
+		DryRun:         notifier.DryRun,
+	}
+	if prAgent, ok := agent.(PullRequestAgent); ok {
+		pr := prAgent.PullRequest()
+		event.Number, event.Title, event.Body, event.Url = pr.Number, pr.Title, pr.Body, pr.HtmlUrl
+		event.Sha = pr.MergeCommitSha
+		event.Sender = prAgent.Sender().DisplayName()
+	}
+	if command, ok := agent.(*commandAgent); ok {
+		event.Command, event.RequestedBy = command.command.String(), command.user
+	}
+	return event
+}
+
+// createDeployReporters collects the reporters interested in a deploy of the agent's hook. A dry
+// run is only recorded in the history, no GitHub deployment or chat notification is sent.
+func createDeployReporters(agent HookAgent, event *DeployEvent) []DeployReporter {
+	var reporters []DeployReporter
+	if event.DryRun {
+		if historyStore != nil {
+			reporters = append(reporters, historyReporter{historyStore})
+		}
+		return reporters
+	}
+	if deployment := createGithubDeployment(agent); deployment != nil {
+		// The deployment is created by its first report, so GitHub never delays the Jenkins notify.
+		deployment.CorrelationId = event.CorrelationId
+		reporters = append(reporters, deployment)
+	}
+	for _, channel := range matchNotificationChannels(agent.Environment(), agent.HookProject()) {
+		reporters = append(reporters, channel)
+	}
+	if historyStore != nil {
+		reporters = append(reporters, historyReporter{historyStore})
+	}
+	return reporters
+}
+
+func reportDeploy(reporters []DeployReporter, event *DeployEvent, status string) {
+	event.Status, event.Time = status, time.Now()
+	for _, reporter := range reporters {
+		reporter.Report(*event)
+	}
+}
+
+// hookDispatches counts the dispatches of received hooks still running. Once draining is closed
+// at shutdown, deploys are no longer debounced and builds are no longer followed.
+var (
+	hookDispatches sync.WaitGroup
+	draining       = make(chan struct{})
+	drainOnce      sync.Once
+)
+
+// isDraining reports whether prcd is shutting down.
+func isDraining() bool {
+	select {
+	case <-draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainDispatches stops debouncing deploys and following builds, and waits until the running
+// dispatches are done or ctx is done. It returns false if ctx is done first. The hook server must
+// not accept hooks any more.
+func drainDispatches(ctx context.Context) bool {
+	drainOnce.Do(func() { close(draining) })
+	done := make(chan struct{})
+	go func() {
+		hookDispatches.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		logger.Error("hook dispatches not done before shutdown", "error", ctx.Err())
+		return false
+	}
+}
+
+// dispatchDeploy notifies the matched Jenkins project and reports the deploy progress. The deploys
+// of an entry with debounce_seconds are debounced first.
+func dispatchDeploy(agent HookAgent, notifier *JenkinsNotifier) {
+	if notifier.JenkinsProject.Debounce > 0 {
+		debounceDeploy(agent, notifier)
+		return
+	}
+	event := newDeployEvent(agent, notifier)
+	followDeploy(notifier, createDeployReporters(agent, &event), &event)
+}
+
+// followDeploy notifies Jenkins and, if any reporter is interested, follows the triggered build
+// until it finishes.
+func followDeploy(notifier *JenkinsNotifier, reporters []DeployReporter, event *DeployEvent) {
+	err := notifier.Notify()
+	auditDeploy(event, notifier, err)
+	if err != nil {
+		event.logger().Error("notify jenkins failed", "jenkins_project", event.JenkinsProject, "error", err)
+		event.Error = err.Error()
+		reportDeploy(reporters, event, DeployFailed)
+		return
+	}
+	if notifier.DryRun {
+		reportDeploy(reporters, event, DeployTriggered)
+		return
+	}
+	event.QueueUrl = notifier.QueueUrl
+	trackActiveDeploy(event, notifier)
+	reportDeploy(reporters, event, DeployTriggered)
+	if len(reporters) == 0 {
+		return
+	}
+	defer untrackActiveDeploy(event, notifier)
+
+	build, err := notifier.WaitForBuild(
+		time.Duration(settings.jenkinsPollInterval)*time.Second,
+		time.Duration(settings.jenkinsBuildTimeout)*time.Second,
+		func(buildUrl string) {
+			event.BuildUrl = buildUrl
+			reportDeploy(reporters, event, DeployStarted)
+		})
+	event.BuildUrl, event.Result = build.Url, build.Result
+	if err == errBuildNotFollowed {
+		event.logger().Info("build not followed, prcd is shutting down", "jenkins_project", event.JenkinsProject,
+			"build_url", event.BuildUrl)
+	} else if err != nil {
+		event.logger().Error("follow jenkins build failed", "jenkins_project", event.JenkinsProject, "error", err)
+		event.Error = err.Error()
+		reportDeploy(reporters, event, DeployFailed)
+	} else if build.Succeeded() {
+		reportDeploy(reporters, event, DeploySucceeded)
+	} else {
+		reportDeploy(reporters, event, DeployFailed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type recordingReporter struct {
+	events []DeployEvent
+}
+
+func (reporter *recordingReporter) Report(event DeployEvent) {
+	reporter.events = append(reporter.events, event)
+}
+
+func TestNewDeployEvent(t *testing.T) {
+	agent := GithubPullRequestHookAgent{}
+	if file, e := ioutil.ReadFile("samples/github_pull_request.json"); e == nil {
+		agent.Parse(file)
+	}
+	notifier := JenkinsNotifier{JenkinsProject: JenkinsProject{Name: "production-mingdao"}}
+	event := newDeployEvent(&agent, &notifier)
+	if event.Project != "mingdao" || event.Branch != "master" || event.Environment != "production" ||
+		event.Title != "Fix order list paging" || event.Sender != "akimimi" ||
+		event.Url != "https://github.com/akimimi/mingdao/pull/42" || event.JenkinsProject != "production-mingdao" {
+		t.Errorf("Deploy event error, actual %+v", event)
+	}
+}
+
+func TestFollowDeploy(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/pro/build":
+			w.Header().Set("Location", ts.URL+"/queue/item/7/")
+			w.WriteHeader(http.StatusCreated)
+		case "/queue/item/7/api/json":
+			w.Write([]byte(`{"executable":{"number":3,"url":"` + ts.URL + `/job/pro/3/"}}`))
+		case "/job/pro/3/api/json":
+			w.Write([]byte(`{"building":false,"result":"SUCCESS"}`))
+		}
+	}))
+	defer ts.Close()
+	settings.jenkinsPollInterval, settings.jenkinsBuildTimeout = 0, 5
+
+	reporter := &recordingReporter{}
+	notifier := &JenkinsNotifier{
+		JenkinsHost:    ts.URL,
+		JenkinsUrl:     "/job/<project>/build?token=<token>",
+		JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234"},
+	}
+	event := DeployEvent{JenkinsProject: "pro"}
+	followDeploy(notifier, []DeployReporter{reporter}, &event)
+
+	expected := []string{DeployTriggered, DeployStarted, DeploySucceeded}
+	if len(reporter.events) != len(expected) {
+		t.Fatalf("Deploy events error, expected %v, actual %+v", expected, reporter.events)
+	}
+	for i, status := range expected {
+		if reporter.events[i].Status != status {
+			t.Errorf("Deploy event %d error, expected %s, actual %s", i, status, reporter.events[i].Status)
+		}
+	}
+	if last := reporter.events[2]; last.BuildUrl != ts.URL+"/job/pro/3/" || last.Result != "SUCCESS" {
+		t.Errorf("Deploy result error, actual %+v", last)
+	}
+
+	reporter.events = nil
+	notifier.JenkinsHost = "http://127.0.0.1:1"
+	followDeploy(notifier, []DeployReporter{reporter}, &event)
+	if len(reporter.events) != 1 || reporter.events[0].Status != DeployFailed || reporter.events[0].Error == "" {
+		t.Errorf("Failed notify should report failed, actual %+v", reporter.events)
+	}
+}
+
+func TestFollowDeploy_DryRun(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	dryRuns := jenkinsNotifies.WithLabelValues("dry_run")
+	before := testutil.ToFloat64(dryRuns)
+
+	reporter := &recordingReporter{}
+	notifier := &JenkinsNotifier{
+		JenkinsHost:    ts.URL,
+		JenkinsUrl:     "/job/<project>/build?token=<token>",
+		JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234"},
+		DryRun:         true,
+	}
+	event := DeployEvent{JenkinsProject: "pro", DryRun: true, Project: "dry-run-project", Number: 1}
+	followDeploy(notifier, []DeployReporter{reporter}, &event)
+	if requests != 0 {
+		t.Errorf("A dry run should not notify Jenkins, actual %d requests", requests)
+	}
+	if len(reporter.events) != 1 || reporter.events[0].Status != DeployTriggered || !reporter.events[0].DryRun {
+		t.Errorf("A dry run should be reported as a triggered dry run, actual %+v", reporter.events)
+	}
+	if testutil.ToFloat64(dryRuns)-before != 1 {
+		t.Error("A dry run should be counted in the notify metrics")
+	}
+	if _, ok := findActiveDeploy("dry-run-project", 1); ok {
+		t.Error("A dry run should not be cancellable")
+	}
+}
+
+func TestSendNotice_DryRun(t *testing.T) {
+	historyStore = openTestHistoryStore(t)
+	defer func() { historyStore = nil }()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	host := settings.jenkinsHost
+	defer func() { settings.jenkinsHost = host }()
+	settings.jenkinsHost = ts.URL
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	jenkinsProjectConfigGrp["release-mingdao"] = JenkinsProjectConfig{Environment: "production", VcsProject: "mingdao",
+		Branch: "master", JenkinsProject: "pro", JenkinsToken: "abcd1234", DryRun: true}
+
+	b, _ := ioutil.ReadFile("samples/github_pull_request.json")
+	sendNotice(context.Background(), "delivery-dry-run", BasicHook{HookName: githubHookName("pull_request")}, b)
+	if requests != 0 {
+		t.Errorf("A dry_run entry should not notify Jenkins, actual %d requests", requests)
+	}
+	records, _, _ := historyStore.Query(DeploymentFilter{})
+	if len(records) != 1 || !records[0].DryRun || records[0].Status != DeployTriggered {
+		t.Errorf("A dry run should be recorded, actual %+v", records)
+	}
+}
+
+func TestOnNotify_DryRun(t *testing.T) {
+	defer func() { settings.dryRun = false }()
+	settings.dryRun = true
+	resetDedupCache()
+	r := createGinEngine()
+	r.POST("/notify", onNotify)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/notify", strings.NewReader(`{"hook_name":"unknown_hooks"}`)))
+	response := struct {
+		DryRun bool `json:"dry_run"`
+	}{}
+	if json.Unmarshal(w.Body.Bytes(), &response); !response.DryRun {
+		t.Errorf("The response should mark the dry run, actual %s", w.Body)
+	}
+	if !createNotifier(JenkinsProject{Name: "pro"}).DryRun {
+		t.Error("Notifiers should dry run when the global dry run is set")
+	}
+}
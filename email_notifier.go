@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+// SMTP connection security modes.
+const (
+	SmtpTlsNone     = "none"
+	SmtpStartTls    = "starttls"
+	SmtpImplicitTls = "tls"
+)
+
+// SmtpConfig defines the SMTP server used by an email channel.
+type SmtpConfig struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	From     string `json:"from" yaml:"from"`
+	// Tls is one of "starttls" (default), "tls" for implicit TLS or "none".
+	Tls string `json:"tls" yaml:"tls"`
+}
+
+const emailSubjectTemplate = `[prcd] {{.JenkinsProject}} deploy {{.Status}} ({{.Environment}}/{{.Project}})`
+
+const emailTextTemplate = `Deploy {{.Status}} at {{.Time.Format "2006-01-02 15:04:05 MST"}}
+
+Project:        {{.Project}}
+Branch:         {{.Branch}}
+Environment:    {{.Environment}}
+Jenkins job:    {{.JenkinsProject}}
+{{- if .BuildUrl}}
+Jenkins build:  {{.BuildUrl}}{{end}}
+Result:         {{if .Result}}{{.Result}}{{else}}{{.Status}}{{end}}
+{{- if .Error}}
+Error:          {{.Error}}{{end}}
+{{if .Title}}
+Pull request:   {{.Title}}
+Merged by:      {{.Sender}}
+{{- if .Url}}
+Link:           {{.Url}}{{end}}
+{{- if .Sha}}
+Commit:         {{.Sha}}{{end}}
+
+{{.Body}}
+{{end}}`
+
+const emailHtmlTemplate = `<html><body>
+<h3>Deploy {{.Status}}</h3>
+<table>
+<tr><td>Time</td><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Project</td><td>{{.Project}}</td></tr>
+<tr><td>Branch</td><td>{{.Branch}}</td></tr>
+<tr><td>Environment</td><td>{{.Environment}}</td></tr>
+<tr><td>Jenkins job</td><td>{{.JenkinsProject}}</td></tr>
+{{- if .BuildUrl}}
+<tr><td>Jenkins build</td><td><a href="{{.BuildUrl}}">{{.BuildUrl}}</a></td></tr>{{end}}
+<tr><td>Result</td><td>{{if .Result}}{{.Result}}{{else}}{{.Status}}{{end}}</td></tr>
+{{- if .Error}}
+<tr><td>Error</td><td>{{.Error}}</td></tr>{{end}}
+{{- if .Title}}
+<tr><td>Pull request</td><td>{{if .Url}}<a href="{{.Url}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</td></tr>
+<tr><td>Merged by</td><td>{{.Sender}}</td></tr>
+{{- if .Sha}}
+<tr><td>Commit</td><td>{{.Sha}}</td></tr>{{end}}{{end}}
+</table>
+{{- if .Body}}
+<pre>{{.Body}}</pre>{{end}}
+</body></html>
+`
+
+var (
+	emailSubjectTpl = template.Must(template.New("subject").Parse(emailSubjectTemplate))
+	emailTextTpl    = template.Must(template.New("text").Parse(emailTextTemplate))
+	emailHtmlTpl    = htmltemplate.Must(htmltemplate.New("html").Parse(emailHtmlTemplate))
+)
+
+// sendDeployEmail sends the deploy summary of event to recipients as a plain-text and HTML email.
+func sendDeployEmail(config SmtpConfig, recipients []string, event DeployEvent) error {
+	if config.Host == "" || config.From == "" || len(recipients) == 0 {
+		return errors.New("email channel requires smtp host, from and recipients")
+	}
+	msg, err := buildDeployEmail(config.From, recipients, event)
+	if err != nil {
+		return err
+	}
+	if err := sendSmtpMail(config, recipients, msg); err != nil {
+		return err
+	}
+	event.logger().Info("deploy email sent", "status", event.Status, "project", event.Project,
+		"recipients", strings.Join(recipients, ","))
+	return nil
+}
+
+func buildDeployEmail(from string, recipients []string, event DeployEvent) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := emailSubjectTpl.Execute(&subject, event); err != nil {
+		return nil, err
+	}
+	if err := emailTextTpl.Execute(&text, event); err != nil {
+		return nil, err
+	}
+	if err := emailHtmlTpl.Execute(&html, event); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 12)
+	rand.Read(b)
+	boundary := "prcd-" + hex.EncodeToString(b)
+	var msg bytes.Buffer
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(recipients, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject.String()) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: multipart/alternative; boundary=" + boundary + "\r\n\r\n")
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{{"text/plain", text.String()}, {"text/html", html.String()}} {
+		msg.WriteString("--" + boundary + "\r\n")
+		msg.WriteString("Content-Type: " + part.contentType + "; charset=utf-8\r\n")
+		msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		msg.WriteString(strings.ReplaceAll(part.body, "\n", "\r\n") + "\r\n")
+	}
+	msg.WriteString("--" + boundary + "--\r\n")
+	return msg.Bytes(), nil
+}
+
+func sendSmtpMail(config SmtpConfig, recipients []string, msg []byte) error {
+	mode := config.Tls
+	if mode == "" {
+		mode = SmtpStartTls
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+		if mode == SmtpImplicitTls {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	var conn net.Conn
+	var err error
+	switch mode {
+	case SmtpImplicitTls:
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, tlsConfig)
+	case SmtpStartTls, SmtpTlsNone:
+		conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
+	default:
+		return errors.New("unknown smtp tls mode " + mode)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if mode == SmtpStartTls {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(config.From); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer accepts one plain SMTP session and returns the received commands and data.
+func fakeSmtpServer(t *testing.T) (string, int, chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-fake\r\n250 AUTH PLAIN")
+			case strings.HasPrefix(line, "AUTH"):
+				reply("235 ok")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		received <- lines
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p, received
+}
+
+func TestBuildDeployEmail(t *testing.T) {
+	event := DeployEvent{
+		Status:         DeploySucceeded,
+		Project:        "mingdao",
+		Branch:         "release",
+		Environment:    "production",
+		Title:          "Fix <script> paging",
+		Body:           "Paging skipped the last page.",
+		Sender:         "akimimi",
+		JenkinsProject: "production-mingdao",
+		Result:         "SUCCESS",
+		Time:           time.Now(),
+	}
+	msg, err := buildDeployEmail("prcd@example.com", []string{"a@example.com", "b@example.com"}, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(msg)
+	for _, expected := range []string{
+		"To: a@example.com, b@example.com",
+		"multipart/alternative",
+		"Content-Type: text/plain",
+		"Content-Type: text/html",
+		"Merged by:      akimimi",
+		"Jenkins job:    production-mingdao",
+		"Result:         SUCCESS",
+		"Paging skipped the last page.",
+		"Fix &lt;script&gt; paging",
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("Email should contain %q", expected)
+		}
+	}
+}
+
+func TestSendDeployEmail(t *testing.T) {
+	if err := sendDeployEmail(SmtpConfig{}, nil, DeployEvent{}); err == nil {
+		t.Error("Email without smtp config should fail.")
+	}
+
+	host, port, received := fakeSmtpServer(t)
+	config := SmtpConfig{
+		Host:     host,
+		Port:     port,
+		Username: "prcd",
+		Password: "secret",
+		From:     "prcd@example.com",
+		Tls:      SmtpTlsNone,
+	}
+	channel := NotificationChannel{Name: "email", Config: NotificationChannelConfig{
+		Type:       ChannelEmail,
+		Smtp:       config,
+		Recipients: []string{"a@example.com", "b@example.com"},
+	}}
+	if err := channel.Send(DeployEvent{Status: DeployTriggered, Project: "mingdao", JenkinsProject: "pro"}); err != nil {
+		t.Fatalf("Send email failed with %s", err)
+	}
+	lines := strings.Join(<-received, "\n")
+	for _, expected := range []string{"AUTH PLAIN", "MAIL FROM:<prcd@example.com>",
+		"RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "Subject: [prcd] pro deploy triggered"} {
+		if !strings.Contains(lines, expected) {
+			t.Errorf("SMTP session should contain %q, actual %s", expected, lines)
+		}
+	}
+
+	config.Tls = "ssl3"
+	if err := sendSmtpMail(config, []string{"a@example.com"}, nil); err == nil {
+		t.Error("Unknown tls mode should fail.")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EntryMatch tells whether a projects.yaml entry matches a hook, and if not the first field that
+// failed along with the configured and the hook values.
+type EntryMatch struct {
+	Name     string `json:"name"`
+	Source   string `json:"source"`
+	Matched  bool   `json:"matched"`
+	Failed   string `json:"failed,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// Jenkins is the resolved Jenkins settings of a matched entry.
+	Jenkins []JenkinsSetting `json:"jenkins,omitempty"`
+}
+
+// MatchExplanation is how a hook payload would be handled, worked out without triggering a deploy.
+type MatchExplanation struct {
+	HookName    string       `json:"hook_name"`
+	Agent       string       `json:"agent"`
+	ParseError  string       `json:"parse_error,omitempty"`
+	Project     string       `json:"project"`
+	Branch      string       `json:"branch"`
+	Environment string       `json:"environment"`
+	CanTrigger  bool         `json:"can_trigger"`
+	Matched     []string     `json:"matched"`
+	Entries     []EntryMatch `json:"entries"`
+}
+
+// explainEntry matches a projects.yaml entry the way matchJenkinsProject does. An entry missing
+// jenkins_project or jenkins_token matches but is not usable, so it fails on that field.
+func explainEntry(name string, config JenkinsProjectConfig, environment, project, branch string) EntryMatch {
+	entry := EntryMatch{Name: name, Source: config.Source}
+	for _, field := range []struct{ name, expected, actual string }{
+		{"vcs_project", config.VcsProject, project},
+		{"environment", config.Environment, environment},
+		{"branch", config.Branch, branch},
+	} {
+		if field.expected != field.actual {
+			entry.Failed, entry.Expected, entry.Actual = field.name, field.expected, field.actual
+			return entry
+		}
+	}
+	switch {
+	case config.JenkinsProject == "":
+		entry.Failed = "jenkins_project"
+	case config.JenkinsToken == "":
+		entry.Failed = "jenkins_token"
+	default:
+		entry.Matched = true
+		entry.Jenkins = createNotifier(config.jenkinsProject()).jenkinsSettings()
+	}
+	return entry
+}
+
+// explainMatch parses a hook payload with the agent prcd would choose and matches it against
+// every projects.yaml entry. githubEvent is the X-GitHub-Event header, if any.
+func explainMatch(githubEvent string, b []byte) (MatchExplanation, error) {
+	basicHook := BasicHook{}
+	if err := json.Unmarshal(b, &basicHook); err != nil {
+		return MatchExplanation{}, err
+	}
+	if basicHook.HookName == "" && githubEvent != "" {
+		basicHook.HookName = githubHookName(githubEvent)
+	}
+	agent := createHookAgentByName(basicHook.HookName)
+	explanation := MatchExplanation{HookName: basicHook.HookName, Agent: agent.Name(),
+		Matched: []string{}, Entries: []EntryMatch{}}
+	if err := agent.Parse(b); err != nil {
+		explanation.ParseError = err.Error()
+		return explanation, nil
+	}
+	explanation.Project, explanation.Branch, explanation.Environment = agent.HookProject(), agent.HookBranch(), agent.Environment()
+	explanation.CanTrigger = agent.CanTriggerEvent()
+
+	grp := jenkinsProjectConfigs()
+	names := make([]string, 0, len(grp))
+	for name := range grp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := explainEntry(name, grp[name], explanation.Environment, explanation.Project, explanation.Branch)
+		if entry.Matched {
+			explanation.Matched = append(explanation.Matched, name)
+		}
+		explanation.Entries = append(explanation.Entries, entry)
+	}
+	return explanation, nil
+}
+
+// onExplain explains how the posted hook payload would be matched for an admin, nothing is
+// triggered. Parsing a comment payload may call the GitHub API.
+func onExplain(c *gin.Context) {
+	if !isAdminRequest(c) {
+		return
+	}
+	b, err := c.GetRawData()
+	if err == nil {
+		var explanation MatchExplanation
+		if explanation, err = explainMatch(c.GetHeader("X-GitHub-Event"), b); err == nil {
+			c.JSON(200, explanation)
+			return
+		}
+	}
+	c.JSON(400, gin.H{"error": "invalid payload: " + err.Error()})
+}
+
+// runExplain is the explain subcommand, it prints how a hook payload file would be matched.
+func runExplain(args []string) int {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	configFile := flags.String("jenkins-project-config-file", "/etc/prcd/projects.yaml", "Jenkins Project config file.")
+	githubEvent := flags.String("github-event", "", "GitHub event of the payload, as sent in the X-GitHub-Event header.")
+	registerJenkinsFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: prcd explain [options] <payload file, - for stdin>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	var err error
+	if settings.jenkinsUserApiToken, err = resolveSecret(settings.jenkinsUserApiToken); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSecrets(settings.jenkinsUserApiToken)
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Keep stdout for the explanation.
+	logOutput = os.Stderr
+	if err := loadJenkinsProjectConfig(*configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	explanation, err := explainMatch(*githubEvent, b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid payload:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(explanation, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
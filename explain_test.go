@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplainMatch(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	b, _ := ioutil.ReadFile("samples/github_pull_request.json")
+	b = []byte(strings.Replace(string(b), `"name": "mingdao"`, `"name": "mimixiche-backend"`, -1))
+	b = []byte(strings.Replace(string(b), `"ref": "master"`, `"ref": "release"`, 1))
+	explanation, err := explainMatch("pull_request", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Agent != "GithubPullRequestHookAgent" || explanation.Project != "mimixiche-backend" ||
+		explanation.Branch != "release" || explanation.Environment != "production" || !explanation.CanTrigger {
+		t.Errorf("The parsed hook should be explained, actual %+v", explanation)
+	}
+	if len(explanation.Matched) != 1 || explanation.Matched[0] != "release-backend" {
+		t.Errorf("release-backend should match, actual %v", explanation.Matched)
+	}
+	expected := map[string]EntryMatch{
+		"dev-backend": {Name: "dev-backend", Source: "config/projects.sample.yaml", Failed: "environment",
+			Expected: "debug", Actual: "production"},
+		"dev-backend-php7": {Name: "dev-backend-php7", Source: "config/projects.sample.yaml", Failed: "environment",
+			Expected: "debug", Actual: "production"},
+		"release-backend": {Name: "release-backend", Source: "config/projects.sample.yaml", Matched: true},
+	}
+	for _, entry := range explanation.Entries {
+		jenkins := entry.Jenkins
+		entry.Jenkins = nil
+		if e, ok := expected[entry.Name]; ok && !reflect.DeepEqual(e, entry) {
+			t.Errorf("Entry %s expected %+v, actual %+v", entry.Name, e, entry)
+		}
+		if entry.Matched && (len(jenkins) != 4 || jenkins[0].Name != "host" || jenkins[0].Source != "default") {
+			t.Errorf("The resolved Jenkins settings of %s should be explained, actual %+v", entry.Name, jenkins)
+		}
+	}
+}
+
+func TestExplainEntry(t *testing.T) {
+	config := JenkinsProjectConfig{Environment: "debug", VcsProject: "mingdao", Branch: "develop", JenkinsProject: "dev"}
+	if entry := explainEntry("dev", config, "debug", "mingdao", "feature"); entry.Failed != "branch" ||
+		entry.Expected != "develop" || entry.Actual != "feature" {
+		t.Errorf("The branch should fail, actual %+v", entry)
+	}
+	if entry := explainEntry("dev", config, "debug", "mingdao", "develop"); entry.Matched || entry.Failed != "jenkins_token" {
+		t.Errorf("An entry without token should fail on jenkins_token, actual %+v", entry)
+	}
+}
+
+func TestOnExplain(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	defer func() { settings.explainUrl, settings.adminToken = "", "" }()
+	settings.explainUrl, settings.adminToken = "/debug/explain", "admin-s3cr3t"
+	r := createGinEngine()
+	r.POST(settings.explainUrl, onExplain)
+	b, _ := ioutil.ReadFile("samples/pull_request.json")
+	explain := func(token, payload string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/debug/explain", strings.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := explain("wrong", string(b)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Explain without the admin token should be rejected, actual %d", w.Code)
+	}
+	w := explain("admin-s3cr3t", string(b))
+	explanation := MatchExplanation{}
+	if err := json.Unmarshal(w.Body.Bytes(), &explanation); err != nil || w.Code != 200 {
+		t.Fatalf("Explain should succeed, actual %d %s", w.Code, w.Body)
+	}
+	if explanation.Agent != "PullRequestHookAgent" || explanation.CanTrigger || len(explanation.Entries) != 4 ||
+		explanation.Entries[0].Failed != "vcs_project" {
+		t.Errorf("Explain should report the agent and every entry, actual %+v", explanation)
+	}
+
+	if w := explain("admin-s3cr3t", "not json"); w.Code != 400 {
+		t.Errorf("An invalid payload should be rejected, actual %d", w.Code)
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	output := logOutput
+	defer func() { logOutput = output }()
+	if code := runExplain([]string{"-jenkins-project-config-file", "config/projects.sample.yaml", "samples/pull_request.json"}); code != 0 {
+		t.Errorf("explain should succeed, actual exit code %d", code)
+	}
+	if code := runExplain([]string{"-jenkins-project-config-file", "config/not-exists.yaml", "samples/pull_request.json"}); code != 1 {
+		t.Errorf("explain should fail without a project config, actual exit code %d", code)
+	}
+	if code := runExplain(nil); code != 2 {
+		t.Errorf("explain should require a payload, actual exit code %d", code)
+	}
+}
+
+func TestRunExplain_DefaultProfile(t *testing.T) {
+	saved, output, stdout := settings, logOutput, os.Stdout
+	defer func() { settings, logOutput, os.Stdout = saved, output, stdout }()
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	config := filepath.Join(t.TempDir(), "projects.yaml")
+	ioutil.WriteFile(config, []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+`), 0640)
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	code := runExplain([]string{"-jenkins-project-config-file", config, "-jenkins-host", "http://ci.example.com", "-github-event", "pull_request",
+		"samples/github_pull_request.json"})
+	w.Close()
+	b, _ := ioutil.ReadAll(r)
+	if code != 0 {
+		t.Fatalf("explain should succeed, actual exit code %d", code)
+	}
+
+	explanation := MatchExplanation{}
+	if err := json.Unmarshal(b, &explanation); err != nil || len(explanation.Entries) != 1 {
+		t.Fatalf("explain should print the entry, actual %s %v", b, err)
+	}
+	settingsByName := map[string]JenkinsSetting{}
+	for _, setting := range explanation.Entries[0].Jenkins {
+		settingsByName[setting.Name] = setting
+	}
+	if host := settingsByName["host"]; host.Value != "http://ci.example.com" || host.Source != defaultJenkinsServer {
+		t.Errorf("The default host should come from -jenkins-host, actual %+v", host)
+	}
+	if url := settingsByName["url"]; url.Value != "/job/<project>/build?token=<token>" || url.Source != defaultJenkinsServer {
+		t.Errorf("The default url should be the -jenkins-url default, actual %+v", url)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+// GitHub deployment states posted while a deploy is dispatched.
+const (
+	DeploymentQueued     = "queued"
+	DeploymentInProgress = "in_progress"
+	DeploymentSuccess    = "success"
+	DeploymentFailure    = "failure"
+)
+
+// GithubDeploymentSource is implemented by agents whose hooks come from GitHub repositories.
+type GithubDeploymentSource interface {
+	RepositoryFullName() string
+	CommitSha() string
+}
+
+// GithubDeployment reports the progress of a deploy as a GitHub Deployment of the merged commit.
+type GithubDeployment struct {
+	ApiUrl      string
+	Token       string
+	Repository  string
+	Sha         string
+	Environment string
+
+	CorrelationId string
+
+	// Id is set after Create succeeds.
+	Id int64
+	// createFailed stops Report from creating the deployment again after a failure.
+	createFailed bool
+}
+
+// Create creates the deployment on GitHub.
+func (deployment *GithubDeployment) Create() error {
+	body := map[string]interface{}{
+		"ref":               deployment.Sha,
+		"environment":       deployment.Environment,
+		"auto_merge":        false,
+		"required_contexts": []string{},
+		"description":       "Deploy triggered by prcd",
+	}
+	var created struct {
+		Id int64 `json:"id"`
+	}
+	url := fmt.Sprintf("%s/repos/%s/deployments", deployment.ApiUrl, deployment.Repository)
+	if err := deployment.post(url, body, &created); err != nil {
+		return err
+	}
+	deployment.Id = created.Id
+	deployment.logger().Info("created github deployment", "deployment_id", deployment.Id,
+		"repository", deployment.Repository, "sha", deployment.Sha, "environment", deployment.Environment)
+	return nil
+}
+
+// SetStatus posts a deployment status. targetUrl may be empty if the Jenkins build is not known yet.
+func (deployment *GithubDeployment) SetStatus(state, targetUrl, description string) error {
+	if deployment.Id == 0 {
+		return errors.New("GitHub deployment is not created.")
+	}
+	body := map[string]interface{}{
+		"state":       state,
+		"description": description,
+	}
+	if targetUrl != "" {
+		body["target_url"] = targetUrl
+		body["log_url"] = targetUrl
+	}
+	url := fmt.Sprintf("%s/repos/%s/deployments/%d/statuses", deployment.ApiUrl, deployment.Repository, deployment.Id)
+	if err := deployment.post(url, body, nil); err != nil {
+		return err
+	}
+	deployment.logger().Info("github deployment status", "deployment_id", deployment.Id,
+		"state", state, "target_url", targetUrl)
+	return nil
+}
+
+// Report implements DeployReporter, it maps the deploy status to a deployment status and only
+// logs failures, so GitHub feedback never blocks a deploy. The deployment is created by the first
+// report, after Jenkins is notified.
+func (deployment *GithubDeployment) Report(event DeployEvent) {
+	if deployment.Id == 0 {
+		if deployment.createFailed {
+			return
+		}
+		if err := deployment.Create(); err != nil {
+			deployment.createFailed = true
+			deployment.logger().Error("create github deployment failed", "error", err)
+			return
+		}
+	}
+	state, targetUrl, description := "", event.BuildUrl, ""
+	switch event.Status {
+	case DeployTriggered:
+		state, targetUrl, description = DeploymentQueued, event.QueueUrl, "Jenkins build queued"
+	case DeployStarted:
+		state, description = DeploymentInProgress, "Jenkins build started"
+	case DeploySucceeded:
+		state, description = DeploymentSuccess, "Jenkins build succeeded"
+	case DeployFailed:
+		state, description = DeploymentFailure, event.Error
+		if description == "" {
+			description = "Jenkins build result " + event.Result
+		}
+	default:
+		return
+	}
+	if err := deployment.SetStatus(state, targetUrl, description); err != nil {
+		deployment.logger().Error("github deployment status failed", "deployment_id", deployment.Id,
+			"state", state, "error", err)
+	}
+}
+
+func (deployment *GithubDeployment) logger() Logger {
+	return Logger{CorrelationId: deployment.CorrelationId}
+}
+
+func (deployment *GithubDeployment) post(url string, body interface{}, out interface{}) error {
+	return githubApiRequest("POST", url, deployment.Token, body, out)
+}
+
+// vcsApiClient bounds the GitHub and Gitee API requests, a hanging API must not hold a deploy.
+var vcsApiClient = &http.Client{Timeout: 10 * time.Second}
+
+// githubApiRequest sends a GitHub REST API request, body and out are JSON encoded if not nil.
+func githubApiRequest(method, url, token string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := vcsApiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("GitHub api failed: url=" + url + " status=" + resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// createGithubDeployment returns a deployment for agents of GitHub hooks, or nil if the agent is not
+// from GitHub or no GitHub token is configured.
+func createGithubDeployment(agent HookAgent) *GithubDeployment {
+	source, ok := agent.(GithubDeploymentSource)
+	if !ok || settings.githubToken == "" {
+		return nil
+	}
+	if source.RepositoryFullName() == "" || source.CommitSha() == "" {
+		return nil
+	}
+	return &GithubDeployment{
+		ApiUrl:      strings.TrimSuffix(settings.githubApiUrl, "/"),
+		Token:       settings.githubToken,
+		Repository:  source.RepositoryFullName(),
+		Sha:         source.CommitSha(),
+		Environment: agent.Environment(),
+	}
+}
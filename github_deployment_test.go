@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateGithubDeployment(t *testing.T) {
+	settings.githubToken = ""
+	agent := GithubPullRequestHookAgent{}
+	if file, e := ioutil.ReadFile("samples/github_pull_request.json"); e == nil {
+		agent.Parse(file)
+	}
+	if createGithubDeployment(&agent) != nil {
+		t.Error("GitHub deployment should be disabled without token.")
+	}
+	settings.githubToken = "ghp_test"
+	defer func() { settings.githubToken = "" }()
+	if createGithubDeployment(&PullRequestHookAgent{}) != nil {
+		t.Error("GitHub deployment should not be created for Gitee agents.")
+	}
+	deployment := createGithubDeployment(&agent)
+	if deployment == nil || deployment.Repository != "akimimi/mingdao" ||
+		deployment.Sha != "0899444d680c13ba2122f208f59f5f64517f480b" || deployment.Environment != "production" {
+		t.Errorf("GitHub deployment error, actual %+v", deployment)
+	}
+}
+
+func TestGithubDeployment_Report(t *testing.T) {
+	var mu sync.Mutex
+	var states, targets []string
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token ghp_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/repos/akimimi/mingdao/deployments":
+			if body["ref"] != "abc" || body["environment"] != "production" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":11}`))
+		case "/repos/akimimi/mingdao/deployments/11/statuses":
+			mu.Lock()
+			states = append(states, body["state"].(string))
+			target, _ := body["target_url"].(string)
+			targets = append(targets, target)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer github.Close()
+
+	deployment := &GithubDeployment{
+		ApiUrl:      github.URL,
+		Token:       "ghp_test",
+		Repository:  "akimimi/mingdao",
+		Sha:         "abc",
+		Environment: "production",
+	}
+	if err := deployment.SetStatus(DeploymentQueued, "", ""); err == nil {
+		t.Error("SetStatus should fail before Create.")
+	}
+	if err := deployment.Create(); err != nil || deployment.Id != 11 {
+		t.Fatalf("Create failed with %v, id %d", err, deployment.Id)
+	}
+	event := DeployEvent{QueueUrl: "http://jenkins/queue/item/7/"}
+	for _, status := range []string{DeployTriggered, DeployStarted, DeployFailed} {
+		event.Status = status
+		if status != DeployTriggered {
+			event.BuildUrl, event.Result = "http://jenkins/job/pro/3/", "FAILURE"
+		}
+		deployment.Report(event)
+	}
+
+	expected := []string{DeploymentQueued, DeploymentInProgress, DeploymentFailure}
+	if len(states) != len(expected) {
+		t.Fatalf("Deployment states error, expected %v, actual %v", expected, states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Errorf("Deployment states error, expected %v, actual %v", expected, states)
+		}
+	}
+	if targets[0] != "http://jenkins/queue/item/7/" || targets[2] != "http://jenkins/job/pro/3/" {
+		t.Errorf("Deployment target url error, actual %v", targets)
+	}
+}
+
+func TestGithubDeployment_ReportCreatesAfterNotify(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		if r.URL.Path == "/repos/akimimi/mingdao/deployments" {
+			w.Write([]byte(`{"id":12}`))
+		}
+	}))
+	defer github.Close()
+
+	deployment := &GithubDeployment{ApiUrl: github.URL, Repository: "akimimi/mingdao", Sha: "abc", Environment: "production"}
+	deployment.Report(DeployEvent{Status: DeployTriggered, QueueUrl: "http://jenkins/queue/item/7/"})
+	expected := []string{"/repos/akimimi/mingdao/deployments", "/repos/akimimi/mingdao/deployments/12/statuses"}
+	if len(requests) != 2 || requests[0] != expected[0] || requests[1] != expected[1] {
+		t.Errorf("The first report should create the deployment, expected %v, actual %v", expected, requests)
+	}
+}
+
+func TestGithubDeployment_Timeout(t *testing.T) {
+	hang := make(chan struct{})
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer github.Close()
+	defer close(hang)
+	timeout := vcsApiClient.Timeout
+	defer func() { vcsApiClient.Timeout = timeout }()
+	vcsApiClient.Timeout = 50 * time.Millisecond
+
+	deployment := &GithubDeployment{ApiUrl: github.URL, Repository: "akimimi/mingdao", Sha: "abc", Environment: "production"}
+	deployment.Report(DeployEvent{Status: DeployTriggered})
+	deployment.Report(DeployEvent{Status: DeploySucceeded})
+	if deployment.Id != 0 || !deployment.createFailed {
+		t.Errorf("A hanging GitHub API should fail the creation, actual %+v", deployment)
+	}
+}
@@ -3,9 +3,17 @@ module github.com/akimimi/prcd
 go 1.16
 
 require (
-	github.com/akimimi/config-loader v0.0.0-20210726042344-a1af57d910c7
-	github.com/akimimi/getuigo v0.0.0-20210701100656-906cb1e1a994 // indirect
+	github.com/facebookgo/stack v0.0.0-20160209184415-751773369052 // indirect
 	github.com/gin-gonic/gin v1.7.2
+	github.com/go-yaml/yaml v2.1.0+incompatible
 	github.com/gogap/errors v0.0.0-20210701081805-48fc6910ea07
-	github.com/gogap/logs v0.0.0-20150329044033-31c6d1e28b2c
+	github.com/gogap/stack v0.0.0-20150131034635-fef68dddd4f8 // indirect
+	github.com/prometheus/client_golang v1.11.1
+	go.etcd.io/bbolt v1.3.6
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.3.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
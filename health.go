@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pendingDispatches counts the hook dispatch goroutines currently running.
+var pendingDispatches int64
+
+// onHealthz reports that the process is alive and serving requests.
+func onHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// onReadyz reports whether hooks can be handled: the project config is loaded, the pending dispatch
+// work is below settings.readyMaxPending and, with -readyz-check-jenkins, the default Jenkins host
+// answers. The response is 200 if all checks pass and 503 otherwise, with the details of each check.
+func onReadyz(c *gin.Context) {
+	ready := true
+	checks := gin.H{}
+
+	projectConfigStatus.Lock()
+	configErr, loadedAt := projectConfigStatus.err, projectConfigStatus.loadedAt
+	projectConfigStatus.Unlock()
+	// A failed reload keeps the loaded config, it is reported but does not fail readiness.
+	projectCheck := gin.H{"ok": !loadedAt.IsZero(), "projects": len(jenkinsProjectConfigs())}
+	if configErr != nil {
+		projectCheck["error"] = configErr.Error()
+	} else if loadedAt.IsZero() {
+		projectCheck["error"] = "project config is not loaded"
+	}
+	if !loadedAt.IsZero() {
+		projectCheck["loaded_at"] = loadedAt.Format(time.RFC3339)
+	}
+	ready = ready && projectCheck["ok"].(bool)
+	checks["project_config"] = projectCheck
+
+	pending := atomic.LoadInt64(&pendingDispatches)
+	dispatchOk := settings.readyMaxPending <= 0 || pending < settings.readyMaxPending
+	checks["dispatch"] = gin.H{"ok": dispatchOk, "pending": pending, "max_pending": settings.readyMaxPending}
+	ready = ready && dispatchOk
+
+	if settings.readyCheckJenkins {
+		jenkinsCheck := gin.H{"ok": true, "host": settings.jenkinsHost}
+		if err := checkJenkinsReachable(settings.jenkinsHost); err != nil {
+			jenkinsCheck["ok"], jenkinsCheck["error"] = false, err.Error()
+			ready = false
+		}
+		checks["jenkins"] = jenkinsCheck
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+var readyzClient = &http.Client{Timeout: 3 * time.Second}
+
+// checkJenkinsReachable returns an error if the Jenkins host does not answer, any HTTP response
+// including authentication errors counts as reachable.
+func checkJenkinsReachable(host string) error {
+	resp, err := readyzClient.Get(host)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
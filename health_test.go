@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func getReadyz(t *testing.T) (int, map[string]interface{}) {
+	r := createGinEngine()
+	r.GET("/readyz", onReadyz)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/readyz", nil))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Readyz should return JSON, actual %s", resp.Body.String())
+	}
+	return resp.Code, body
+}
+
+func readyzCheck(body map[string]interface{}, name string) map[string]interface{} {
+	checks, _ := body["checks"].(map[string]interface{})
+	check, _ := checks[name].(map[string]interface{})
+	return check
+}
+
+func TestOnHealthz(t *testing.T) {
+	r := createGinEngine()
+	r.GET("/healthz", onHealthz)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/healthz", nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf("Healthz should return 200, actual %d", resp.Code)
+	}
+}
+
+func TestOnReadyz_ProjectConfig(t *testing.T) {
+	settings.readyMaxPending, settings.readyCheckJenkins = 100, false
+	projectConfigStatus.loadedAt = time.Time{}
+	if err := loadJenkinsProjectConfig("config/not-exists.yaml"); err == nil {
+		t.Fatal("Loading a missing project config should fail.")
+	}
+	code, body := getReadyz(t)
+	if code != http.StatusServiceUnavailable || readyzCheck(body, "project_config")["ok"] != false ||
+		readyzCheck(body, "project_config")["error"] == nil {
+		t.Errorf("Readyz should fail with the project config error, actual %d %v", code, body)
+	}
+
+	if err := loadJenkinsProjectConfig("config/projects.sample.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	code, body = getReadyz(t)
+	if code != http.StatusOK || body["status"] != "ready" || readyzCheck(body, "project_config")["projects"] == float64(0) {
+		t.Errorf("Readyz should pass with the sample project config, actual %d %v", code, body)
+	}
+
+	if err := loadJenkinsProjectConfig("config/not-exists.yaml"); err == nil {
+		t.Fatal("Loading a missing project config should fail.")
+	}
+	code, body = getReadyz(t)
+	if code != http.StatusOK || readyzCheck(body, "project_config")["error"] == nil {
+		t.Errorf("A failed reload should be reported without failing readyz, actual %d %v", code, body)
+	}
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+}
+
+func TestOnReadyz_PendingDispatches(t *testing.T) {
+	if err := loadJenkinsProjectConfig("config/projects.sample.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	settings.readyMaxPending, settings.readyCheckJenkins = 2, false
+	atomic.AddInt64(&pendingDispatches, 2)
+	defer atomic.AddInt64(&pendingDispatches, -2)
+	code, body := getReadyz(t)
+	if code != http.StatusServiceUnavailable || readyzCheck(body, "dispatch")["pending"] != float64(2) {
+		t.Errorf("Readyz should fail with too many pending dispatches, actual %d %v", code, body)
+	}
+	settings.readyMaxPending = 0
+	if code, body = getReadyz(t); code != http.StatusOK {
+		t.Errorf("Readyz should pass with the pending limit disabled, actual %d %v", code, body)
+	}
+}
+
+func TestOnReadyz_Jenkins(t *testing.T) {
+	if err := loadJenkinsProjectConfig("config/projects.sample.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	settings.readyMaxPending, settings.readyCheckJenkins = 100, true
+	defer func() { settings.readyCheckJenkins = false }()
+
+	settings.jenkinsHost = ts.URL
+	if code, body := getReadyz(t); code != http.StatusOK || readyzCheck(body, "jenkins")["ok"] != true {
+		t.Errorf("Readyz should pass with a reachable Jenkins, actual %d %v", code, body)
+	}
+	settings.jenkinsHost = "http://127.0.0.1:1"
+	if code, body := getReadyz(t); code != http.StatusServiceUnavailable || readyzCheck(body, "jenkins")["ok"] != false {
+		t.Errorf("Readyz should fail with an unreachable Jenkins, actual %d %v", code, body)
+	}
+}
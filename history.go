@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statuses of a deployment record before it is dispatched, dispatched records take the deploy
+// statuses (triggered, started, succeeded or failed).
+const (
+	HistoryReceived    = "received"
+	HistoryParseFailed = "parse_failed"
+	HistoryIgnored     = "ignored"
+	HistoryUnmatched   = "unmatched"
+	HistoryMatched     = "matched"
+	// HistoryFolded is the status of a debounced trigger replaced by a later one of the same job.
+	HistoryFolded = "folded"
+)
+
+var (
+	deploymentsBucket    = []byte("deployments")
+	correlationIdsBucket = []byte("correlation_ids")
+)
+
+// DeploymentRecord is the history of one received hook, from receipt to the result of the Jenkins
+// build it triggered, if any.
+type DeploymentRecord struct {
+	Id            uint64 `json:"id"`
+	CorrelationId string `json:"correlation_id"`
+	HookName      string `json:"hook_name"`
+	HookId        int    `json:"hook_id"`
+	Agent         string `json:"agent"`
+	Project       string `json:"project"`
+	Branch        string `json:"branch"`
+	Environment   string `json:"environment"`
+
+	// Pull request fields, empty if the hook is not a pull request.
+	Number int    `json:"pull_request,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Url    string `json:"url,omitempty"`
+	Sender string `json:"sender,omitempty"`
+	Sha    string `json:"sha,omitempty"`
+
+	JenkinsProjects []string `json:"jenkins_projects"`
+	DryRun          bool     `json:"dry_run,omitempty"`
+	Status          string   `json:"status"`
+	MatchReason     string   `json:"match_reason,omitempty"`
+	QueueUrl        string   `json:"queue_url,omitempty"`
+	BuildUrl        string   `json:"build_url,omitempty"`
+	Result          string   `json:"result,omitempty"`
+	Error           string   `json:"error,omitempty"`
+
+	// Folded are the correlation ids of the triggers folded into this deploy, FoldedInto is the
+	// correlation id of the deploy a folded trigger went into.
+	Folded     []string `json:"folded,omitempty"`
+	FoldedInto string   `json:"folded_into,omitempty"`
+
+	ReceivedAt time.Time  `json:"received_at"`
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// setHook fills the record with the fields of a parsed hook.
+func (record *DeploymentRecord) setHook(agent HookAgent) {
+	record.Agent = agent.Name()
+	record.Project, record.Branch, record.Environment = agent.HookProject(), agent.HookBranch(), agent.Environment()
+	if prAgent, ok := agent.(PullRequestAgent); ok {
+		pr := prAgent.PullRequest()
+		record.Number, record.Title, record.Url, record.Sha = pr.Number, pr.Title, pr.HtmlUrl, pr.MergeCommitSha
+		record.Sender = prAgent.Sender().DisplayName()
+	}
+}
+
+// setDeployEvent updates the record with the progress of the deploy dispatched for its hook.
+func (record *DeploymentRecord) setDeployEvent(event DeployEvent) {
+	record.Status, record.DryRun = event.Status, event.DryRun
+	if len(event.Folded) > 0 {
+		record.Folded = event.Folded
+	}
+	record.Branch, record.Environment = event.Branch, event.Environment
+	if event.Sha != "" {
+		record.Sha = event.Sha
+	}
+	found := false
+	for _, name := range record.JenkinsProjects {
+		found = found || name == event.JenkinsProject
+	}
+	if !found {
+		record.JenkinsProjects = append(record.JenkinsProjects, event.JenkinsProject)
+	}
+	record.QueueUrl, record.BuildUrl, record.Result, record.Error = event.QueueUrl, event.BuildUrl, event.Result, event.Error
+	t := event.Time
+	switch event.Status {
+	case DeployTriggered:
+		record.NotifiedAt = &t
+	case DeployStarted:
+		record.StartedAt = &t
+	case DeploySucceeded, DeployFailed:
+		record.FinishedAt = &t
+	}
+}
+
+// DeploymentFilter selects deployment records, zero fields match every record. Page starts at 1.
+type DeploymentFilter struct {
+	Project     string
+	Environment string
+	Branch      string
+	Status      string
+	Since       time.Time
+	Until       time.Time
+	Page        int
+	PerPage     int
+}
+
+func (filter *DeploymentFilter) match(record *DeploymentRecord) bool {
+	return (filter.Project == "" || filter.Project == record.Project) &&
+		(filter.Environment == "" || filter.Environment == record.Environment) &&
+		(filter.Branch == "" || filter.Branch == record.Branch) &&
+		(filter.Status == "" || filter.Status == record.Status) &&
+		(filter.Since.IsZero() || !record.ReceivedAt.Before(filter.Since)) &&
+		(filter.Until.IsZero() || record.ReceivedAt.Before(filter.Until))
+}
+
+// HistoryStore keeps deployment records in an embedded bolt database, records are keyed by an
+// increasing id and indexed by correlation id.
+type HistoryStore struct {
+	db *bolt.DB
+}
+
+// historyStore is the store of received hooks, it is nil if the history is disabled.
+var historyStore *HistoryStore
+
+func openHistoryStore(filename string) (*HistoryStore, error) {
+	db, err := bolt.Open(filename, 0660, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{deploymentsBucket, correlationIdsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &HistoryStore{db: db}, nil
+}
+
+func (store *HistoryStore) Close() error {
+	return store.db.Close()
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func putDeploymentRecord(tx *bolt.Tx, record *DeploymentRecord) error {
+	deployments := tx.Bucket(deploymentsBucket)
+	if record.Id == 0 {
+		id, err := deployments.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.Id = id
+	}
+	record.UpdatedAt = time.Now()
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := deployments.Put(historyKey(record.Id), b); err != nil {
+		return err
+	}
+	if record.CorrelationId == "" {
+		return nil
+	}
+	return tx.Bucket(correlationIdsBucket).Put([]byte(record.CorrelationId), historyKey(record.Id))
+}
+
+// Save adds a record, or replaces it if its id is set.
+func (store *HistoryStore) Save(record *DeploymentRecord) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return putDeploymentRecord(tx, record)
+	})
+}
+
+// UpdateByCorrelationId applies update to the latest record of a correlation id and saves it, a
+// new record is added if there is none.
+func (store *HistoryStore) UpdateByCorrelationId(correlationId string, update func(record *DeploymentRecord)) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		record := &DeploymentRecord{CorrelationId: correlationId, ReceivedAt: time.Now()}
+		if key := tx.Bucket(correlationIdsBucket).Get([]byte(correlationId)); key != nil {
+			if b := tx.Bucket(deploymentsBucket).Get(key); b != nil {
+				if err := json.Unmarshal(b, record); err != nil {
+					return err
+				}
+			}
+		}
+		update(record)
+		return putDeploymentRecord(tx, record)
+	})
+}
+
+// Query returns a page of the records matching filter, newest first, and the count of all
+// matching records.
+func (store *HistoryStore) Query(filter DeploymentFilter) ([]DeploymentRecord, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PerPage < 1 {
+		filter.PerPage = 20
+	}
+	offset := (filter.Page - 1) * filter.PerPage
+	records, total := []DeploymentRecord{}, 0
+	err := store.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deploymentsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			record := DeploymentRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if !filter.match(&record) {
+				continue
+			}
+			if total >= offset && len(records) < filter.PerPage {
+				records = append(records, record)
+			}
+			total++
+		}
+		return nil
+	})
+	return records, total, err
+}
+
+// LastDeploys returns the newest dispatched record of each project and environment, sorted by
+// project and environment.
+func (store *HistoryStore) LastDeploys() ([]DeploymentRecord, error) {
+	records, seen := []DeploymentRecord{}, map[string]bool{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deploymentsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			record := DeploymentRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			key := record.Project + "\x00" + record.Environment
+			if (record.NotifiedAt == nil && record.Status != DeployFailed) || seen[key] {
+				continue
+			}
+			seen[key] = true
+			records = append(records, record)
+		}
+		return nil
+	})
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Project != records[j].Project {
+			return records[i].Project < records[j].Project
+		}
+		return records[i].Environment < records[j].Environment
+	})
+	return records, err
+}
+
+// Prune deletes the records received before the given time, and the oldest records beyond
+// maxRecords if it is positive. It returns the count of deleted records.
+func (store *HistoryStore) Prune(before time.Time, maxRecords int) (int, error) {
+	deleted := 0
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		deployments, correlationIds := tx.Bucket(deploymentsBucket), tx.Bucket(correlationIdsBucket)
+		var expired []DeploymentRecord
+		kept := 0
+		c := deployments.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			record := DeploymentRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if (before.IsZero() || !record.ReceivedAt.Before(before)) && (maxRecords <= 0 || kept < maxRecords) {
+				kept++
+			} else {
+				expired = append(expired, record)
+			}
+		}
+		// Keys are deleted after the iteration, deleting under a cursor may skip keys.
+		for _, record := range expired {
+			key := historyKey(record.Id)
+			if err := deployments.Delete(key); err != nil {
+				return err
+			}
+			if string(correlationIds.Get([]byte(record.CorrelationId))) == string(key) {
+				if err := correlationIds.Delete([]byte(record.CorrelationId)); err != nil {
+					return err
+				}
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	return deleted, err
+}
+
+// pruneHistory applies the retention settings to the history every interval.
+func pruneHistory(store *HistoryStore, interval time.Duration) {
+	for {
+		before := time.Time{}
+		if settings.historyRetentionDays > 0 {
+			before = time.Now().AddDate(0, 0, -int(settings.historyRetentionDays))
+		}
+		if deleted, err := store.Prune(before, int(settings.historyMaxRecords)); err != nil {
+			logger.Error("prune deployment history failed", "error", err)
+		} else if deleted > 0 {
+			logger.Info("deployment history pruned", "deleted", deleted)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// saveDeploymentRecord saves a record to the history and publishes it to the dashboard, if the
+// history is enabled.
+func saveDeploymentRecord(log Logger, record *DeploymentRecord) {
+	if historyStore == nil {
+		return
+	}
+	if err := historyStore.Save(record); err != nil {
+		log.Error("save deployment record failed", "error", err)
+		return
+	}
+	dashboardFeed.publish(*record)
+}
+
+// historyReporter records the progress of deploys in the history.
+type historyReporter struct {
+	store *HistoryStore
+}
+
+// Report updates the record of the hook that dispatched the deploy.
+func (reporter historyReporter) Report(event DeployEvent) {
+	var updated DeploymentRecord
+	err := reporter.store.UpdateByCorrelationId(event.CorrelationId, func(record *DeploymentRecord) {
+		if record.Agent == "" {
+			record.Agent, record.Project = event.Agent, event.Project
+			record.Number, record.Title, record.Url, record.Sender = event.Number, event.Title, event.Url, event.Sender
+		}
+		record.setDeployEvent(event)
+		updated = *record
+	})
+	if err != nil {
+		event.logger().Error("save deployment record failed", "status", event.Status, "error", err)
+		return
+	}
+	dashboardFeed.publish(updated)
+}
+
+// onListDeployments serves the deployment history, filtered by the project, environment, branch,
+// status, since and until (RFC 3339) query parameters and paged by page and per_page.
+func onListDeployments(c *gin.Context) {
+	if historyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"errmsg": "deployment history is disabled"})
+		return
+	}
+	filter := DeploymentFilter{
+		Project:     c.Query("project"),
+		Environment: c.Query("environment"),
+		Branch:      c.Query("branch"),
+		Status:      c.Query("status"),
+		Page:        1,
+		PerPage:     20,
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"errmsg": "invalid " + name + ", expected RFC 3339 time"})
+				return
+			}
+		}
+	}
+	for name, n := range map[string]*int{"page": &filter.Page, "per_page": &filter.PerPage} {
+		if v := c.Query(name); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil || *n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"errmsg": "invalid " + name + ", expected a positive number"})
+				return
+			}
+		}
+	}
+	if filter.PerPage > 100 {
+		filter.PerPage = 100
+	}
+	records, total, err := historyStore.Query(filter)
+	if err != nil {
+		logger.Error("query deployment history failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"errmsg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":       total,
+		"page":        filter.Page,
+		"per_page":    filter.PerPage,
+		"deployments": records,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestHistoryStore(t *testing.T) *HistoryStore {
+	store, err := openHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestHistoryStore_Query(t *testing.T) {
+	store := openTestHistoryStore(t)
+	base := time.Date(2021, 8, 1, 10, 0, 0, 0, time.UTC)
+	for i, r := range []DeploymentRecord{
+		{Project: "toboto/mingdao", Environment: "production", Status: DeploySucceeded},
+		{Project: "toboto/mingdao", Environment: "debug", Status: HistoryIgnored},
+		{Project: "toboto/mingdao", Environment: "production", Status: DeployFailed},
+		{Project: "akimimi/prcd", Environment: "production", Status: DeploySucceeded},
+	} {
+		r.ReceivedAt = base.Add(time.Duration(i) * time.Hour)
+		if err := store.Save(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, total, err := store.Query(DeploymentFilter{Project: "toboto/mingdao", Environment: "production"})
+	if err != nil || total != 2 || len(records) != 2 || records[0].Status != DeployFailed {
+		t.Errorf("Query should return the matching records newest first, actual %d %v %v", total, records, err)
+	}
+	records, total, _ = store.Query(DeploymentFilter{Status: DeploySucceeded, Since: base.Add(time.Hour)})
+	if total != 1 || records[0].Project != "akimimi/prcd" {
+		t.Errorf("Query should filter by status and time, actual %v", records)
+	}
+	records, total, _ = store.Query(DeploymentFilter{Until: base.Add(2 * time.Hour), Page: 2, PerPage: 1})
+	if total != 2 || len(records) != 1 || records[0].Id != 1 {
+		t.Errorf("Query should page the matching records, actual %d %v", total, records)
+	}
+}
+
+func TestHistoryStore_Prune(t *testing.T) {
+	store := openTestHistoryStore(t)
+	now := time.Now()
+	for i, correlationId := range []string{"a", "b", "c", "d"} {
+		r := DeploymentRecord{CorrelationId: correlationId, ReceivedAt: now.AddDate(0, 0, i-3)}
+		if err := store.Save(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if deleted, err := store.Prune(now.AddDate(0, 0, -2), 0); err != nil || deleted != 1 {
+		t.Errorf("Prune should delete records older than the retention, actual %d %v", deleted, err)
+	}
+	if deleted, _ := store.Prune(time.Time{}, 2); deleted != 1 {
+		t.Errorf("Prune should keep the newest max records, actual %d", deleted)
+	}
+	records, total, _ := store.Query(DeploymentFilter{})
+	if total != 2 || records[0].CorrelationId != "d" || records[1].CorrelationId != "c" {
+		t.Errorf("The newest records should be kept, actual %v", records)
+	}
+	store.UpdateByCorrelationId("a", func(record *DeploymentRecord) { record.Status = DeployTriggered })
+	if _, total, _ := store.Query(DeploymentFilter{}); total != 3 {
+		t.Errorf("Updating a pruned correlation id should add a record, actual %d", total)
+	}
+}
+
+func TestHistoryReporter(t *testing.T) {
+	store := openTestHistoryStore(t)
+	received := time.Now().Add(-time.Minute)
+	store.Save(&DeploymentRecord{CorrelationId: "delivery-1", Agent: "PullRequestHookAgent", Project: "toboto/mingdao",
+		Status: HistoryMatched, JenkinsProjects: []string{"pro"}, ReceivedAt: received})
+
+	reporter := historyReporter{store}
+	event := DeployEvent{CorrelationId: "delivery-1", Project: "toboto/mingdao", Branch: "master",
+		Environment: "production", JenkinsProject: "pro", QueueUrl: "http://jenkins/queue/item/7/"}
+	for _, status := range []string{DeployTriggered, DeployStarted, DeploySucceeded} {
+		event.Status, event.Time = status, time.Now()
+		if status == DeploySucceeded {
+			event.BuildUrl, event.Result = "http://jenkins/job/pro/3/", "SUCCESS"
+		}
+		reporter.Report(event)
+	}
+
+	records, total, _ := store.Query(DeploymentFilter{})
+	if total != 1 {
+		t.Fatalf("Deploy events should update the hook record, actual %v", records)
+	}
+	r := records[0]
+	if r.Status != DeploySucceeded || r.Result != "SUCCESS" || len(r.JenkinsProjects) != 1 ||
+		r.NotifiedAt == nil || r.StartedAt == nil || r.FinishedAt == nil || !r.ReceivedAt.Equal(received) {
+		t.Errorf("Record should carry the deploy progress, actual %+v", r)
+	}
+}
+
+func TestOnListDeployments(t *testing.T) {
+	historyStore = openTestHistoryStore(t)
+	defer func() { historyStore = nil }()
+	for i := 0; i < 3; i++ {
+		historyStore.Save(&DeploymentRecord{Project: "toboto/mingdao", Environment: "production",
+			Status: DeploySucceeded, ReceivedAt: time.Now()})
+	}
+	r := createGinEngine()
+	r.GET("/api/deployments", onListDeployments)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/api/deployments?project=toboto/mingdao&per_page=2&page=2", nil))
+	body := struct {
+		Total       int
+		Page        int
+		Deployments []DeploymentRecord
+	}{}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if resp.Code != http.StatusOK || body.Total != 3 || body.Page != 2 || len(body.Deployments) != 1 {
+		t.Errorf("Deployments should be paged, actual %d %s", resp.Code, resp.Body.String())
+	}
+
+	for _, query := range []string{"since=yesterday", "page=0", "per_page=x"} {
+		resp = httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest("GET", "/api/deployments?"+query, nil))
+		if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "invalid") {
+			t.Errorf("Query %s should be rejected, actual %d %s", query, resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestSendNotice_RecordsHistory(t *testing.T) {
+	historyStore = openTestHistoryStore(t)
+	defer func() { historyStore = nil }()
+
+	sendNotice(context.Background(), "delivery-2", BasicHook{HookName: "merge_request_hooks", HookId: 9}, []byte(`not json`))
+	sendNotice(context.Background(), "delivery-3", BasicHook{HookName: "unknown_hooks"}, []byte(`{}`))
+	records, _, _ := historyStore.Query(DeploymentFilter{})
+	if len(records) != 2 || records[1].Status != HistoryParseFailed || records[1].HookId != 9 || records[1].Error == "" {
+		t.Errorf("A failed parse should be recorded, actual %+v", records)
+	}
+}
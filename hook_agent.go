@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"github.com/gogap/logs"
 	"strings"
 )
 
@@ -16,6 +15,13 @@ type HookAgent interface {
 	Environment() string
 }
 
+// PullRequestAgent is implemented by agents parsed from pull request hooks, it provides the pull
+// request details for notifications.
+type PullRequestAgent interface {
+	PullRequest() PullRequest
+	Sender() User
+}
+
 // PullRequestHookAgent is the agent for pull request transfer.
 type PullRequestHookAgent struct {
 	prHook   PullRequestHook
@@ -33,8 +39,9 @@ func (agent *PullRequestHookAgent) Parse(b []byte) error {
 	agent.isParsed = false
 	if e = json.Unmarshal(b, &agent.prHook); e == nil {
 		agent.isParsed = true
-		logs.Debug("PR:", agent.prHook.PullRequest.Title, "/", agent.prHook.PullRequest.Base.Repo.Name,
-			"/", agent.prHook.PullRequest.Base.Ref, "/", agent.prHook.PullRequest.State)
+		logger.Debug("pull request parsed", "title", agent.prHook.PullRequest.Title,
+			"repo", agent.prHook.PullRequest.Base.Repo.Name, "ref", agent.prHook.PullRequest.Base.Ref,
+			"state", agent.prHook.PullRequest.State)
 	}
 	return e
 }
@@ -66,6 +73,214 @@ func (agent *PullRequestHookAgent) Environment() string {
 	return hookBranchEnvironment(agent.HookBranch())
 }
 
+// PullRequest returns the parsed pull request.
+func (agent *PullRequestHookAgent) PullRequest() PullRequest {
+	return agent.prHook.PullRequest
+}
+
+// Sender returns the user who triggered the hook, e.g. the one who merged the pull request.
+func (agent *PullRequestHookAgent) Sender() User {
+	return agent.prHook.Sender
+}
+
+// GithubPullRequestHookAgent is the agent for GitHub pull request transfer.
+// GitHub shares the pull request payload layout with Gitee, but reports a merged pull request
+// as "closed" with the merged flag set.
+type GithubPullRequestHookAgent struct {
+	PullRequestHookAgent
+}
+
+// Name is the agent name implementation.
+func (agent *GithubPullRequestHookAgent) Name() string {
+	return "GithubPullRequestHookAgent"
+}
+
+// CanTriggerEvent determines whether an agent can trigger following events.
+// The GitHub pull request webhook can trigger CD events only if the pull request is merged.
+func (agent *GithubPullRequestHookAgent) CanTriggerEvent() bool {
+	return agent.prHook.PullRequest.Merged
+}
+
+// RepositoryFullName returns the "owner/repo" name of the pull request base repository.
+func (agent *GithubPullRequestHookAgent) RepositoryFullName() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.prHook.PullRequest.Base.Repo.FullName
+}
+
+// CommitSha returns the merge commit sha of the pull request.
+func (agent *GithubPullRequestHookAgent) CommitSha() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.prHook.PullRequest.MergeCommitSha
+}
+
+// CommentAgent is implemented by agents parsed from pull request comment hooks, comments are
+// handled as commands instead of triggering events.
+type CommentAgent interface {
+	HookAgent
+	PullRequestAgent
+	Comment() Comment
+	ReplyComment(body string) error
+}
+
+// NoteHookAgent is the agent for pull request comment transfer.
+type NoteHookAgent struct {
+	noteHook NoteHook
+	isParsed bool
+}
+
+// Name is the agent name implementation.
+func (agent *NoteHookAgent) Name() string {
+	return "NoteHookAgent"
+}
+
+// Parse unmarshal given bytes to agent.
+func (agent *NoteHookAgent) Parse(b []byte) error {
+	var e error
+	agent.isParsed = false
+	if e = json.Unmarshal(b, &agent.noteHook); e == nil {
+		agent.isParsed = true
+		logger.Debug("note parsed", "noteable_type", agent.noteHook.NoteableType,
+			"number", agent.noteHook.PullRequest.Number, "user", agent.noteHook.Comment.User.Login,
+			"body", agent.noteHook.Comment.Body)
+	}
+	return e
+}
+
+// CanTriggerEvent determines whether an agent can trigger following events.
+// Comments never trigger events directly, they are handled as comment commands.
+func (agent *NoteHookAgent) CanTriggerEvent() bool {
+	return false
+}
+
+// HookBranch returns the base branch name of the commented pull request.
+func (agent *NoteHookAgent) HookBranch() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.noteHook.PullRequest.Base.Ref
+}
+
+// HookProject returns the project name of the commented pull request.
+func (agent *NoteHookAgent) HookProject() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.noteHook.PullRequest.Base.Repo.Name
+}
+
+// Environment returns "debug" or "production" based on the branch and project.
+func (agent *NoteHookAgent) Environment() string {
+	return hookBranchEnvironment(agent.HookBranch())
+}
+
+// PullRequest returns the commented pull request, it is empty if the comment is not on a pull request.
+func (agent *NoteHookAgent) PullRequest() PullRequest {
+	if agent.noteHook.NoteableType != "PullRequest" {
+		return PullRequest{}
+	}
+	return agent.noteHook.PullRequest
+}
+
+// Sender returns the user who commented.
+func (agent *NoteHookAgent) Sender() User {
+	return agent.noteHook.Sender
+}
+
+// Comment returns the parsed comment.
+func (agent *NoteHookAgent) Comment() Comment {
+	return agent.noteHook.Comment
+}
+
+// ReplyComment comments on the pull request through the Gitee API.
+func (agent *NoteHookAgent) ReplyComment(body string) error {
+	pr := agent.PullRequest()
+	return replyGiteeComment(pr.Base.Repo.FullName, pr.Number, body)
+}
+
+// GithubCommentHookAgent is the agent for GitHub pull request comment transfer.
+// The commented pull request is fetched from the GitHub API while parsing.
+type GithubCommentHookAgent struct {
+	commentHook GithubIssueCommentHook
+	pr          PullRequest
+	isParsed    bool
+}
+
+// Name is the agent name implementation.
+func (agent *GithubCommentHookAgent) Name() string {
+	return "GithubCommentHookAgent"
+}
+
+// Parse unmarshal given bytes to agent.
+func (agent *GithubCommentHookAgent) Parse(b []byte) error {
+	agent.isParsed, agent.pr = false, PullRequest{}
+	if e := json.Unmarshal(b, &agent.commentHook); e != nil {
+		return e
+	}
+	hook := agent.commentHook
+	if hook.Action == "created" && hook.Issue.PullRequest != nil {
+		pr, e := fetchGithubPullRequest(hook.Repository.FullName, hook.Issue.Number)
+		if e != nil {
+			return e
+		}
+		agent.pr = pr
+	}
+	agent.isParsed = true
+	logger.Debug("github comment parsed", "repo", hook.Repository.FullName, "number", hook.Issue.Number,
+		"user", hook.Comment.User.Login, "body", hook.Comment.Body)
+	return nil
+}
+
+// CanTriggerEvent determines whether an agent can trigger following events.
+// Comments never trigger events directly, they are handled as comment commands.
+func (agent *GithubCommentHookAgent) CanTriggerEvent() bool {
+	return false
+}
+
+// HookBranch returns the base branch name of the commented pull request.
+func (agent *GithubCommentHookAgent) HookBranch() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.pr.Base.Ref
+}
+
+// HookProject returns the project name of the commented pull request.
+func (agent *GithubCommentHookAgent) HookProject() string {
+	if !agent.isParsed {
+		return ""
+	}
+	return agent.commentHook.Repository.Name
+}
+
+// Environment returns "debug" or "production" based on the branch and project.
+func (agent *GithubCommentHookAgent) Environment() string {
+	return hookBranchEnvironment(agent.HookBranch())
+}
+
+// PullRequest returns the commented pull request, it is empty if the comment is not on a pull request.
+func (agent *GithubCommentHookAgent) PullRequest() PullRequest {
+	return agent.pr
+}
+
+// Sender returns the user who commented.
+func (agent *GithubCommentHookAgent) Sender() User {
+	return agent.commentHook.Sender
+}
+
+// Comment returns the parsed comment.
+func (agent *GithubCommentHookAgent) Comment() Comment {
+	return agent.commentHook.Comment
+}
+
+// ReplyComment comments on the pull request through the GitHub API.
+func (agent *GithubCommentHookAgent) ReplyComment(body string) error {
+	return replyGithubComment(agent.commentHook.Repository.FullName, agent.commentHook.Issue.Number, body)
+}
+
 // PushTagHookAgent is the agent for pull request transfer.
 type PushTagHookAgent struct {
 	pushHook PushTagHook
@@ -83,7 +298,8 @@ func (agent *PushTagHookAgent) Parse(b []byte) error {
 	agent.isParsed = false
 	if e = json.Unmarshal(b, &agent.pushHook); e == nil {
 		agent.isParsed = true
-		logs.Debug("TagPush:", agent.pushHook.Ref, "/", agent.pushHook.Project.Name, "/", agent.pushHook.Project.FullName)
+		logger.Debug("push parsed", "ref", agent.pushHook.Ref, "repo", agent.pushHook.Project.Name,
+			"full_name", agent.pushHook.Project.FullName)
 	}
 	return e
 }
@@ -165,17 +381,42 @@ func createHookAgentByName(name string) HookAgent {
 	if name == "tag_push_hooks" || name == "push_hooks" {
 		return &PushTagHookAgent{}
 	}
+	if name == "note_hooks" {
+		return &NoteHookAgent{}
+	}
+	if name == githubHookName("pull_request") {
+		return &GithubPullRequestHookAgent{}
+	}
+	if name == githubHookName("issue_comment") {
+		return &GithubCommentHookAgent{}
+	}
 	return &DefaultHookAgent{}
 }
 
+// githubHookName maps a GitHub event, which is delivered in the X-GitHub-Event header instead of
+// the payload, to the hook name used for agent selection.
+func githubHookName(event string) string {
+	return "github_" + event
+}
+
 func createNotifierByAgent(agent HookAgent) *JenkinsNotifier {
 	project := matchJenkinsProject(agent.Environment(), agent.HookProject(), agent.HookBranch())
+	notifier := createNotifier(project)
+	notifier.Branch = agent.HookBranch()
+	if prAgent, ok := agent.(PullRequestAgent); ok {
+		notifier.Sha = prAgent.PullRequest().MergeCommitSha
+	}
+	return notifier
+}
+
+func createNotifier(project JenkinsProject) *JenkinsNotifier {
 	notifier := JenkinsNotifier{
 		JenkinsHost:    settings.jenkinsHost,
 		JenkinsUrl:     settings.jenkinsNotifyUrl,
 		JenkinsProject: project,
 		UserName:       settings.jenkinsUserName,
 		UserApiToken:   settings.jenkinsUserApiToken,
+		DryRun:         settings.dryRun || project.DryRun,
 	}
 	return &notifier
 }
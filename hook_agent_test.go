@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -325,3 +327,97 @@ func TestCreateNotifierByAgent(t *testing.T) {
 		t.Error("Create notifier failed!")
 	}
 }
+
+func TestGithubPullRequestHookAgent(t *testing.T) {
+	agent := GithubPullRequestHookAgent{}
+	filename := "samples/github_pull_request.json"
+	if file, e := ioutil.ReadFile(filename); e != nil {
+		panic(e)
+	} else {
+		agent.Parse(file)
+	}
+	if agent.Name() != "GithubPullRequestHookAgent" {
+		t.Error("GithubPullRequestHookAgent name is not correct")
+	}
+	if agent.HookProject() != "mingdao" || agent.HookBranch() != "master" || agent.Environment() != "production" {
+		t.Errorf("GitHub pull request parse failed, project %s, branch %s, environment %s",
+			agent.HookProject(), agent.HookBranch(), agent.Environment())
+	}
+	if agent.RepositoryFullName() != "akimimi/mingdao" {
+		t.Errorf("Repository is not correct, expected %s, actual %s", "akimimi/mingdao", agent.RepositoryFullName())
+	}
+	if agent.CommitSha() != "0899444d680c13ba2122f208f59f5f64517f480b" {
+		t.Errorf("Commit sha is not correct, actual %s", agent.CommitSha())
+	}
+	if !agent.CanTriggerEvent() {
+		t.Error("Merged GitHub pull request should trigger events.")
+	}
+	agent.prHook.PullRequest.Merged = false
+	if agent.CanTriggerEvent() {
+		t.Error("Closed GitHub pull request without merge should not trigger events.")
+	}
+
+	if createHookAgentByName(githubHookName("pull_request")).Name() != "GithubPullRequestHookAgent" {
+		t.Error("GitHub pull request agent is not created by hook name")
+	}
+}
+
+func TestNoteHookAgent(t *testing.T) {
+	agent := NoteHookAgent{}
+	if file, e := ioutil.ReadFile("samples/note_hook.json"); e != nil {
+		panic(e)
+	} else if e := agent.Parse(file); e != nil {
+		t.Fatal(e)
+	}
+	if agent.Name() != "NoteHookAgent" || agent.CanTriggerEvent() {
+		t.Error("NoteHookAgent should not trigger events")
+	}
+	if agent.HookProject() != "mingdao" || agent.HookBranch() != "develop" || agent.Environment() != "debug" {
+		t.Errorf("Note hook parse failed, project %s, branch %s", agent.HookProject(), agent.HookBranch())
+	}
+	if agent.Comment().Body != "/deploy debug" || agent.Comment().User.Login != "toboto" ||
+		agent.PullRequest().Number != 9 || agent.PullRequest().Head.Ref != "dosomething" {
+		t.Errorf("Note hook comment parse failed, comment %+v", agent.Comment())
+	}
+	agent.noteHook.NoteableType = "Issue"
+	if agent.PullRequest().Number != 0 {
+		t.Error("Comment on issue should not have a pull request")
+	}
+	if createHookAgentByName("note_hooks").Name() != "NoteHookAgent" {
+		t.Error("Note hook agent is not created by hook name")
+	}
+}
+
+func TestGithubCommentHookAgent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/akimimi/mingdao/pulls/42" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"number":42,"state":"closed","merged":true,"merge_commit_sha":"abc",
+			"base":{"ref":"master","repo":{"name":"mingdao","full_name":"akimimi/mingdao"}}}`))
+	}))
+	defer ts.Close()
+	settings.githubApiUrl = ts.URL
+
+	agent := GithubCommentHookAgent{}
+	if file, e := ioutil.ReadFile("samples/github_issue_comment.json"); e != nil {
+		panic(e)
+	} else if e := agent.Parse(file); e != nil {
+		t.Fatal(e)
+	}
+	if agent.HookProject() != "mingdao" || agent.HookBranch() != "master" || agent.Environment() != "production" {
+		t.Errorf("GitHub comment parse failed, project %s, branch %s", agent.HookProject(), agent.HookBranch())
+	}
+	if agent.Comment().Body != "/redeploy" || !agent.PullRequest().Merged || agent.PullRequest().Number != 42 {
+		t.Errorf("GitHub comment pull request parse failed, %+v", agent.PullRequest())
+	}
+	if createHookAgentByName(githubHookName("issue_comment")).Name() != "GithubCommentHookAgent" {
+		t.Error("GitHub comment agent is not created by hook name")
+	}
+
+	ts.Close()
+	if e := agent.Parse([]byte(`{"action":"created","issue":{"number":1,"pull_request":{}},"repository":{"full_name":"a/b"}}`)); e == nil {
+		t.Error("Parse should fail if the pull request cannot be fetched")
+	}
+}
@@ -7,6 +7,25 @@ type Project struct {
 	FullName string `json:"full_name"`
 }
 
+// User is the struct for an account in VCS
+type User struct {
+	Id       int    `json:"id"`
+	Login    string `json:"login"`
+	Name     string `json:"name"`
+	UserName string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// DisplayName returns the most readable name of the user.
+func (u User) DisplayName() string {
+	for _, name := range []string{u.Name, u.Login, u.UserName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
 // Branch is the struct for a branch data in VCS
 type Branch struct {
 	Label string  `json:"label"`
@@ -17,13 +36,26 @@ type Branch struct {
 
 // PullRequest is the struct for a pull request record in VCS
 type PullRequest struct {
-	Id        int    `json:"id"`
-	State     string `json:"state"`
-	Title     string `json:"title"`
-	Body      string `json:"body"`
-	Base      Branch `json:"base"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	Id             int    `json:"id"`
+	Number         int    `json:"number"`
+	State          string `json:"state"`
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	Head           Branch `json:"head"`
+	Base           Branch `json:"base"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSha string `json:"merge_commit_sha"`
+	HtmlUrl        string `json:"html_url"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// Comment is the struct for a comment on a pull request or issue in VCS
+type Comment struct {
+	Id      int    `json:"id"`
+	Body    string `json:"body"`
+	HtmlUrl string `json:"html_url"`
+	User    User   `json:"user"`
 }
 
 // BasicHook contains the common parameters for a VCS webhook.
@@ -37,6 +69,7 @@ type BasicHook struct {
 type PullRequestHook struct {
 	BasicHook   `json:",inline"`
 	PullRequest PullRequest `json:"pull_request"`
+	Sender      User        `json:"sender"`
 }
 
 // PushTagHook is the push and tag webhook struct.
@@ -45,3 +78,27 @@ type PushTagHook struct {
 	Ref       string  `json:"ref"`
 	Project   Project `json:"repository"`
 }
+
+// NoteHook is the comment webhook struct, only comments on pull requests are handled.
+type NoteHook struct {
+	BasicHook    `json:",inline"`
+	NoteableType string      `json:"noteable_type"`
+	Comment      Comment     `json:"comment"`
+	PullRequest  PullRequest `json:"pull_request"`
+	Sender       User        `json:"sender"`
+}
+
+// GithubIssueCommentHook is the GitHub issue_comment webhook struct. GitHub delivers comments on
+// pull requests as issue comments, the issue only links to the pull request.
+type GithubIssueCommentHook struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int `json:"number"`
+		PullRequest *struct {
+			Url string `json:"url"`
+		} `json:"pull_request"`
+	} `json:"issue"`
+	Comment    Comment `json:"comment"`
+	Repository Project `json:"repository"`
+	Sender     User    `json:"sender"`
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"github.com/gogap/errors"
-	"github.com/gogap/logs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 // JenkinsNotifier defines a notify struct which contains CD host, url, project and user information.
@@ -15,6 +25,36 @@ type JenkinsNotifier struct {
 	JenkinsProject JenkinsProject
 	UserName       string
 	UserApiToken   string
+	CorrelationId  string
+	// HookName and HookId identify the hook the deploy is dispatched for.
+	HookName string
+	HookId   int
+
+	// Branch and Sha replace <branch> and <sha> in the notify url, e.g. as build parameters.
+	Branch string
+	Sha    string
+
+	// DryRun makes Notify log the request instead of sending it.
+	DryRun bool
+
+	// NotifyStatus is the response status of Notify, 0 if no response is received.
+	NotifyStatus int
+	// QueueUrl is the queue item location returned by Jenkins after a successful Notify.
+	QueueUrl string
+
+	// ctx carries the trace of the hook delivery into Jenkins requests.
+	ctx context.Context
+}
+
+// JenkinsBuild is the result of a triggered Jenkins build.
+type JenkinsBuild struct {
+	Url    string
+	Result string
+}
+
+// Succeeded returns true if Jenkins reports the build as SUCCESS.
+func (build *JenkinsBuild) Succeeded() bool {
+	return build.Result == "SUCCESS"
 }
 
 // Notify executes notify based on CD information in the struct.
@@ -22,22 +62,22 @@ func (notifier *JenkinsNotifier) Notify() error {
 	if notifier.JenkinsProject.Name == "" || notifier.JenkinsProject.Token == "" {
 		return errors.New("Jenkins Project config is not correct.")
 	}
-
-	url := notifier.notifyUrl()
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return err
-	}
-	username, apiToken := notifier.UserName, notifier.UserApiToken
-	if notifier.JenkinsProject.HasJenkinsConfig() {
-		username, apiToken = notifier.JenkinsProject.Username, notifier.JenkinsProject.UserApiToken
+	if notifier.DryRun {
+		notifier.logger().Info("dry run, jenkins project not notified", "jenkins_project", notifier.JenkinsProject.Name,
+			"url", notifier.notifyUrl())
+		observeDryRunNotify()
+		return nil
 	}
-	req.SetBasicAuth(username, apiToken)
-	resp, err := http.DefaultClient.Do(req)
+
+	start := time.Now()
+	resp, err := notifier.request("notify", "POST", notifier.notifyUrl())
 	if err != nil {
+		observeJenkinsNotify(0, start)
 		return err
 	}
 	defer resp.Body.Close()
+	notifier.NotifyStatus = resp.StatusCode
+	observeJenkinsNotify(resp.StatusCode, start)
 
 	// 读取一小段响应体用于诊断（Jenkins 触发成功一般是 201 Created + Location: /queue/item/...）。
 	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
@@ -47,22 +87,246 @@ func (notifier *JenkinsNotifier) Notify() error {
 	// Jenkins 触发构建一般返回 201 Created（带 Location 指向 queue item），
 	// 老的判定只接受 200 OK，会把 201 当成失败、把任意 200 页面当成成功，这里改为接受所有 2xx。
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		logs.Info("Notified to project ", notifier.JenkinsProject.Name,
-			" status=", resp.Status, " location=", location, " body=", bodySnippet)
+		notifier.QueueUrl = location
+		notifier.logger().Info("notified jenkins project", "jenkins_project", notifier.JenkinsProject.Name,
+			"status", resp.StatusCode, "location", location, "body", bodySnippet)
 		return nil
 	}
-	return errors.New("Notify failed: project=" + notifier.JenkinsProject.Name +
-		" status=" + resp.Status + " body=" + bodySnippet)
+	return redactError(errors.New("Notify failed: project=" + notifier.JenkinsProject.Name +
+		" status=" + resp.Status + " body=" + bodySnippet))
+}
+
+// server returns the Jenkins server the project is notified on, see resolveJenkinsServer. The
+// default profile is the host, url and credentials of the notifier.
+func (notifier *JenkinsNotifier) server() JenkinsServerConfig {
+	server, _ := resolveJenkinsServer(notifier.JenkinsProject, notifier.defaultServer())
+	return server
+}
+
+// jenkinsSettings returns the resolved Jenkins settings of the project and their sources.
+func (notifier *JenkinsNotifier) jenkinsSettings() []JenkinsSetting {
+	_, fields := resolveJenkinsServer(notifier.JenkinsProject, notifier.defaultServer())
+	return fields
+}
+
+func (notifier *JenkinsNotifier) defaultServer() JenkinsServerConfig {
+	return JenkinsServerConfig{Host: notifier.JenkinsHost, Url: notifier.JenkinsUrl, Username: notifier.UserName,
+		UserApiToken: notifier.UserApiToken}
 }
 
 func (notifier *JenkinsNotifier) notifyUrl() string {
-	host := notifier.JenkinsHost
-	url := notifier.JenkinsUrl
-	if notifier.JenkinsProject.HasJenkinsConfig() {
-		host = notifier.JenkinsProject.Host
-		url = notifier.JenkinsProject.Url
-	}
+	server := notifier.server()
+	host, url := server.Host, server.Url
 	url = strings.Replace(url, "<project>", notifier.JenkinsProject.Name, 1)
 	url = strings.Replace(url, "<token>", notifier.JenkinsProject.Token, 1)
+	url = strings.Replace(url, "<branch>", neturl.QueryEscape(notifier.Branch), 1)
+	url = strings.Replace(url, "<sha>", notifier.Sha, 1)
 	return host + url
 }
+
+func (notifier *JenkinsNotifier) logger() Logger {
+	return Logger{CorrelationId: notifier.CorrelationId}
+}
+
+func (notifier *JenkinsNotifier) credentials() (string, string) {
+	server := notifier.server()
+	return server.Username, server.UserApiToken
+}
+
+// jenkinsClientKey is what tells the HTTP clients of Jenkins servers apart.
+type jenkinsClientKey struct {
+	caCerts            string
+	insecureSkipVerify bool
+	timeout            time.Duration
+}
+
+// jenkinsClients are the HTTP clients of the Jenkins servers with their own TLS or timeout, they
+// are shared to reuse connections.
+var jenkinsClients = struct {
+	sync.Mutex
+	m map[jenkinsClientKey]*http.Client
+}{m: make(map[jenkinsClientKey]*http.Client)}
+
+// jenkinsClient returns the HTTP client for a Jenkins server, http.DefaultClient unless the server
+// sets a CA file, skips verification or has a timeout.
+func jenkinsClient(server JenkinsServerConfig) *http.Client {
+	key := jenkinsClientKey{server.caCerts, server.InsecureSkipVerify, server.Timeout}
+	if key == (jenkinsClientKey{}) {
+		return http.DefaultClient
+	}
+	jenkinsClients.Lock()
+	defer jenkinsClients.Unlock()
+	if client, ok := jenkinsClients.m[key]; ok {
+		return client
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: key.insecureSkipVerify}
+	if key.caCerts != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM([]byte(key.caCerts))
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: key.timeout}
+	jenkinsClients.m[key] = client
+	return client
+}
+
+// WaitForBuild follows the queue item of a notified project until the build starts, calls started
+// with the build url, and then waits for the build result.
+// It gives up after timeout, polling Jenkins every interval.
+func (notifier *JenkinsNotifier) WaitForBuild(interval, timeout time.Duration, started func(buildUrl string)) (JenkinsBuild, error) {
+	build := JenkinsBuild{}
+	if notifier.QueueUrl == "" {
+		return build, errors.New("Jenkins did not return a queue item location.")
+	}
+	deadline := time.Now().Add(timeout)
+
+	var queueItem struct {
+		Cancelled  bool `json:"cancelled"`
+		Executable struct {
+			Url string `json:"url"`
+		} `json:"executable"`
+	}
+	for build.Url == "" {
+		if err := notifier.getJson(notifier.QueueUrl, &queueItem); err != nil {
+			return build, err
+		}
+		if queueItem.Cancelled {
+			build.Result = "CANCELLED"
+			return build, nil
+		}
+		if build.Url = queueItem.Executable.Url; build.Url == "" {
+			if time.Now().After(deadline) {
+				return build, errors.New("Timeout waiting for queue item " + notifier.QueueUrl)
+			}
+			if !pollWait(interval) {
+				return build, errBuildNotFollowed
+			}
+		}
+	}
+	if started != nil {
+		started(build.Url)
+	}
+
+	var buildInfo struct {
+		Building bool   `json:"building"`
+		Result   string `json:"result"`
+	}
+	for {
+		if err := notifier.getJson(build.Url, &buildInfo); err != nil {
+			return build, err
+		}
+		if !buildInfo.Building && buildInfo.Result != "" {
+			build.Result = buildInfo.Result
+			return build, nil
+		}
+		if time.Now().After(deadline) {
+			return build, errors.New("Timeout waiting for build " + build.Url)
+		}
+		if !pollWait(interval) {
+			return build, errBuildNotFollowed
+		}
+	}
+}
+
+// errBuildNotFollowed is returned by WaitForBuild when prcd shuts down before the build finishes.
+var errBuildNotFollowed = errors.New("prcd is shutting down, the build is not followed")
+
+// pollWait waits for the next poll of Jenkins. It returns false at once if prcd is shutting down.
+func pollWait(interval time.Duration) bool {
+	select {
+	case <-time.After(interval):
+		return true
+	case <-draining:
+		return false
+	}
+}
+
+// Cancel cancels the build triggered by Notify, it removes the queue item if the build is not
+// started yet, or stops the running build.
+func (notifier *JenkinsNotifier) Cancel() error {
+	i := strings.Index(notifier.QueueUrl, "/queue/item/")
+	if i < 0 {
+		return errors.New("Jenkins did not return a queue item location.")
+	}
+	var queueItem struct {
+		Executable struct {
+			Url string `json:"url"`
+		} `json:"executable"`
+	}
+	if err := notifier.getJson(notifier.QueueUrl, &queueItem); err != nil {
+		return err
+	}
+	url := queueItem.Executable.Url
+	if url == "" {
+		id := strings.Trim(notifier.QueueUrl[i+len("/queue/item/"):], "/")
+		url = notifier.QueueUrl[:i] + "/queue/cancelItem?id=" + id
+	} else {
+		url = strings.TrimSuffix(url, "/") + "/stop"
+	}
+	resp, err := notifier.request("cancel", "POST", url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Jenkins redirects after cancelling, 3xx means the request is accepted.
+	if resp.StatusCode >= 400 {
+		return errors.New("Cancel failed: url=" + url + " status=" + resp.Status)
+	}
+	notifier.logger().Info("cancelled jenkins project", "jenkins_project", notifier.JenkinsProject.Name, "url", url)
+	return nil
+}
+
+func (notifier *JenkinsNotifier) getJson(url string, v interface{}) error {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	resp, err := notifier.request("api", "GET", url+"api/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Jenkins api failed: url=" + url + " status=" + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request sends an authenticated request to Jenkins in a client span, the trace context is
+// propagated in the request headers.
+func (notifier *JenkinsNotifier) request(operation, method, url string) (*http.Response, error) {
+	ctx := notifier.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, "jenkins "+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("prcd.jenkins_project", notifier.JenkinsProject.Name),
+			semconv.HTTPMethodKey.String(method),
+		))
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		err = redactError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+	req.SetBasicAuth(notifier.credentials())
+	injectTraceContext(ctx, req)
+	resp, err := jenkinsClient(notifier.server()).Do(req)
+	if err != nil {
+		// Transport errors carry the url, which holds the project token.
+		err = redactError(err)
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
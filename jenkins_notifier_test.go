@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestJenkinsNotifier_NotifyUrl(t *testing.T) {
@@ -44,7 +49,7 @@ func TestJenkinsNotifier_NotifyUrl_WithProjectConfig(t *testing.T) {
 	}
 }
 
-func TestJenkinsNotifier_NotifyUrl_WithInvalidProjectConfig(t *testing.T) {
+func TestJenkinsNotifier_NotifyUrl_WithPartialProjectConfig(t *testing.T) {
 	notifier := JenkinsNotifier{
 		JenkinsHost: "http://notify.website.com",
 		JenkinsUrl:  "/<project>/notify?token=<token>",
@@ -59,9 +64,9 @@ func TestJenkinsNotifier_NotifyUrl_WithInvalidProjectConfig(t *testing.T) {
 		UserName:     "",
 		UserApiToken: "",
 	}
-	// project 自身的 username/apiToken 缺失 → HasJenkinsConfig() 为 false，
-	// notifyUrl() 回退到全局 host/url 拼接。
-	expected := "http://notify.website.com/pro/notify?token=abcd1234"
+	// project 自身的 username/apiToken 缺失时只有这两项回退到全局配置，
+	// host/url 仍使用 project 自身的。
+	expected := "http://project-notify.website.com/pro/project-notify?token=abcd1234"
 	if notifier.notifyUrl() != expected {
 		t.Errorf("Notify url error, expected %s, actual %s", expected, notifier.notifyUrl())
 	}
@@ -107,3 +112,156 @@ func TestJenkinsNotifier_Notify(t *testing.T) {
 		t.Errorf("Notify failed with %s", err)
 	}
 }
+
+func TestJenkinsNotifier_WaitForBuild(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pro/notify":
+			w.Header().Set("Location", ts.URL+"/queue/item/7/")
+			w.WriteHeader(http.StatusCreated)
+		case "/queue/item/7/api/json":
+			w.Write([]byte(`{"executable":{"number":3,"url":"` + ts.URL + `/job/pro/3/"}}`))
+		case "/job/pro/3/api/json":
+			w.Write([]byte(`{"building":false,"result":"SUCCESS"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	notifier := JenkinsNotifier{
+		JenkinsHost:    ts.URL,
+		JenkinsUrl:     "/<project>/notify?token=<token>",
+		JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234"},
+	}
+	if _, err := notifier.WaitForBuild(time.Millisecond, time.Second, nil); err == nil {
+		t.Error("WaitForBuild should fail before Notify.")
+	}
+	if err := notifier.Notify(); err != nil {
+		t.Fatalf("Notify failed with %s", err)
+	}
+	if notifier.QueueUrl != ts.URL+"/queue/item/7/" {
+		t.Errorf("Queue url error, actual %s", notifier.QueueUrl)
+	}
+	startedUrl := ""
+	build, err := notifier.WaitForBuild(time.Millisecond, time.Second, func(buildUrl string) {
+		startedUrl = buildUrl
+	})
+	if err != nil {
+		t.Fatalf("WaitForBuild failed with %s", err)
+	}
+	if startedUrl != ts.URL+"/job/pro/3/" || build.Url != startedUrl || !build.Succeeded() {
+		t.Errorf("Build error, started %s, build %+v", startedUrl, build)
+	}
+}
+
+func TestJenkinsNotifier_NotifyUrl_BranchAndSha(t *testing.T) {
+	notifier := JenkinsNotifier{
+		JenkinsHost:    "http://notify.website.com",
+		JenkinsUrl:     "/job/<project>/buildWithParameters?token=<token>&BRANCH=<branch>&SHA=<sha>",
+		JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234"},
+		Branch:         "feature/paging",
+		Sha:            "abc",
+	}
+	expected := "http://notify.website.com/job/pro/buildWithParameters?token=abcd1234&BRANCH=feature%2Fpaging&SHA=abc"
+	if notifier.notifyUrl() != expected {
+		t.Errorf("Notify url error, expected %s, actual %s", expected, notifier.notifyUrl())
+	}
+}
+
+func TestJenkinsNotifier_Cancel(t *testing.T) {
+	var cancelled []string
+	started := false
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/queue/item/7/api/json":
+			if started {
+				w.Write([]byte(`{"executable":{"number":3,"url":"` + ts.URL + `/job/pro/3/"}}`))
+			} else {
+				w.Write([]byte(`{}`))
+			}
+		case "/queue/cancelItem", "/job/pro/3/stop":
+			cancelled = append(cancelled, r.Method+" "+r.URL.RequestURI())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	notifier := JenkinsNotifier{JenkinsProject: JenkinsProject{Name: "pro"}}
+	if err := notifier.Cancel(); err == nil {
+		t.Error("Cancel should fail before Notify.")
+	}
+	notifier.QueueUrl = ts.URL + "/queue/item/7/"
+	if err := notifier.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	started = true
+	if err := notifier.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cancelled) != 2 || cancelled[0] != "POST /queue/cancelItem?id=7" || cancelled[1] != "POST /job/pro/3/stop" {
+		t.Errorf("Cancel requests error, actual %v", cancelled)
+	}
+}
+
+func TestJenkinsNotifier_JenkinsServer(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, _ := r.BasicAuth(); user != "deployer" || token != "s3cr3t" || r.URL.Path != "/job/pro/build" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0640)
+
+	server := JenkinsServerConfig{Host: ts.URL, Url: "/job/<project>/build?token=<token>", Username: "deployer",
+		UserApiToken: "s3cr3t", CaFile: caFile}
+	if problem := readJenkinsServerCaFile(&server); problem != "" {
+		t.Fatal(problem)
+	}
+	notifier := JenkinsNotifier{
+		JenkinsHost:    "http://127.0.0.1:1",
+		JenkinsUrl:     "/<project>/notify?token=<token>",
+		JenkinsProject: JenkinsProject{Name: "pro", Token: "abcd1234", Server: "ci-prod", ServerConfig: server},
+	}
+	if err := notifier.Notify(); err != nil || notifier.NotifyStatus != http.StatusCreated {
+		t.Errorf("The project should be notified on its profile, actual %d %v", notifier.NotifyStatus, err)
+	}
+
+	notifier.JenkinsProject.ServerConfig.caCerts = ""
+	if err := notifier.Notify(); err == nil {
+		t.Error("The server certificate should not be trusted without the CA file")
+	}
+	notifier.JenkinsProject.ServerConfig.InsecureSkipVerify = true
+	if err := notifier.Notify(); err != nil {
+		t.Errorf("Notify should skip the verification, actual %v", err)
+	}
+
+	notifier.JenkinsProject.ServerConfig.Url += "&slow=1"
+	notifier.JenkinsProject.ServerConfig.Timeout = 50 * time.Millisecond
+	if err := notifier.Notify(); err == nil {
+		t.Error("Notify should time out")
+	}
+}
+
+func TestJenkinsNotifier_WaitForBuild_Draining(t *testing.T) {
+	useFakeClock(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"executable":{}}`))
+	}))
+	defer ts.Close()
+
+	notifier := JenkinsNotifier{QueueUrl: ts.URL + "/queue/item/7/"}
+	drainDispatches(context.Background())
+	if _, err := notifier.WaitForBuild(time.Hour, 2*time.Hour, nil); err != errBuildNotFollowed {
+		t.Errorf("WaitForBuild should stop polling when prcd shuts down, actual %v", err)
+	}
+}
@@ -1,6 +1,20 @@
 package main
 
-import cl "github.com/akimimi/config-loader"
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
 
 // JenkinsProject defines a structure for jenkins project.
 type JenkinsProject struct {
@@ -10,12 +24,82 @@ type JenkinsProject struct {
 	Url          string
 	Username     string
 	UserApiToken string
+	// Server is the jenkins_servers profile the project is notified on, empty for the default one.
+	Server       string
+	ServerConfig JenkinsServerConfig
+	// DryRun skips the Jenkins notify, the deploy is only logged and recorded.
+	DryRun bool
+	// Debounce is the quiet period the deploys of the project wait for, 0 if they are not debounced.
+	Debounce time.Duration
+	// Entry is the name of the matched entry and Source the project config file it is loaded from.
+	Entry  string
+	Source string
 }
 
-// HasJenkinsConfig returns True if the project is configured as a dependent project
-// which does not use the default Jenkins Host and Url configuration.
-func (p *JenkinsProject) HasJenkinsConfig() bool {
-	return p.Host != "" && p.Url != "" && p.Username != "" && p.UserApiToken != ""
+// JenkinsSetting is a Jenkins setting of a project after the fallbacks, with where it comes from:
+// entry, the name of its profile, default or credentialsNotInherited. A set user_api_token is
+// masked.
+type JenkinsSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// credentialsNotInherited is the source of the credentials of an entry replacing the host of its
+// profile without its own credentials.
+const credentialsNotInherited = "not inherited"
+
+// resolveJenkinsServer returns the Jenkins server a project is notified on. The profile of the
+// entry, or defaults without one, is the base. The entry's jenkins_host and jenkins_url each
+// replace their field on their own. jenkins_username and jenkins_user_api_token replace the
+// credentials together, and an entry replacing the host without them gets no credentials, so
+// those of the profile are never sent to another host.
+func resolveJenkinsServer(project JenkinsProject, defaults JenkinsServerConfig) (JenkinsServerConfig, []JenkinsSetting) {
+	server, base := defaults, defaultJenkinsServer
+	if project.Server != "" {
+		server, base = project.ServerConfig, project.Server
+	}
+	credentials := base
+	switch {
+	case project.Username != "" || project.UserApiToken != "":
+		server.Username, server.UserApiToken, credentials = project.Username, project.UserApiToken, "entry"
+	case project.Host != "":
+		server.Username, server.UserApiToken, credentials = "", "", credentialsNotInherited
+	}
+	var fields []JenkinsSetting
+	for _, field := range []struct {
+		name     string
+		value    string
+		resolved *string
+	}{
+		{"host", project.Host, &server.Host},
+		{"url", project.Url, &server.Url},
+		{"username", "", &server.Username},
+		{"user_api_token", "", &server.UserApiToken},
+	} {
+		source := base
+		if field.value != "" {
+			*field.resolved, source = field.value, "entry"
+		}
+		if field.name == "username" || field.name == "user_api_token" {
+			source = credentials
+		}
+		value := *field.resolved
+		if field.name == "user_api_token" && value != "" {
+			value = redactedValue
+		}
+		fields = append(fields, JenkinsSetting{Name: field.name, Value: value, Source: source})
+	}
+	return server, fields
+}
+
+// formatJenkinsSettings formats resolved settings as name=value (source) pairs.
+func formatJenkinsSettings(fields []JenkinsSetting) string {
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		pairs = append(pairs, fmt.Sprintf("%s=%q (%s)", field.Name, field.Value, field.Source))
+	}
+	return strings.Join(pairs, " ")
 }
 
 // JenkinsProjectConfig defines the structure for jenkins configure.
@@ -26,31 +110,492 @@ type JenkinsProjectConfig struct {
 	JenkinsProject string `json:"jenkins_project" yaml:"jenkins_project"`
 	JenkinsToken   string `json:"jenkins_token" yaml:"jenkins_token"`
 
-	// The following parameters are not forced, default values will be used if one of the following parameter is empty.
+	// The following parameters are not forced, each one that is empty is taken from the profile of
+	// the entry.
 	JenkinsHost         string `json:"jenkins_host" yaml:"jenkins_host"`
 	JenkinsUrl          string `json:"jenkins_url" yaml:"jenkins_url"`
 	JenkinsUsername     string `json:"jenkins_username" yaml:"jenkins_username"`
 	JenkinsUserApiToken string `json:"jenkins_user_api_token" yaml:"jenkins_user_api_token"`
+
+	// JenkinsServer names the jenkins_servers profile used instead of the default one.
+	JenkinsServer string `json:"jenkins_server" yaml:"jenkins_server"`
+	// Server is the profile named by JenkinsServer, filled in when the config is read.
+	Server JenkinsServerConfig `json:"-" yaml:"-"`
+
+	// DryRun runs the deploys of the entry up to the Jenkins notify without making it.
+	DryRun bool `json:"dry_run" yaml:"dry_run"`
+	// DebounceSeconds makes a trigger wait until the Jenkins job has had no trigger for this many
+	// seconds, the triggers in between are folded into the last one.
+	DebounceSeconds int `json:"debounce_seconds" yaml:"debounce_seconds"`
+
+	// Source is the file the entry is loaded from.
+	Source string `json:"source,omitempty" yaml:"-"`
+}
+
+// defaultJenkinsServer is the name of the profile made of the -jenkins-* flags, used by the
+// entries without jenkins_server.
+const defaultJenkinsServer = "default"
+
+// JenkinsServerConfig is a Jenkins server profile of the jenkins_servers section of the project
+// config. url is the notify URL template, as -jenkins-url.
+type JenkinsServerConfig struct {
+	Host         string `json:"host" yaml:"host"`
+	Url          string `json:"url" yaml:"url"`
+	Username     string `json:"username" yaml:"username"`
+	UserApiToken string `json:"user_api_token" yaml:"user_api_token"`
+
+	// CaFile adds the PEM certificates of the file to the system ones to verify the server.
+	CaFile             string        `json:"ca_file" yaml:"ca_file"`
+	InsecureSkipVerify bool          `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"`
+
+	// caCerts is the content of CaFile, read with the config.
+	caCerts string
+}
+
+// jenkinsProjectFile is the layout of a project config file, the jenkins_servers profiles next to
+// the entries.
+type jenkinsProjectFile struct {
+	JenkinsServers map[string]JenkinsServerConfig  `yaml:"jenkins_servers"`
+	Entries        map[string]JenkinsProjectConfig `yaml:",inline"`
+}
+
+// jenkinsProjectConfigGrp is the loaded project config, it is replaced as a whole on reload and
+// read through jenkinsProjectConfigs.
+var (
+	jenkinsProjectConfigGrp map[string]JenkinsProjectConfig
+	jenkinsProjectConfigMu  sync.RWMutex
+)
+
+// jenkinsProjectConfigs returns the current project config. A loaded config is never modified, so
+// the returned map can be read without locking.
+func jenkinsProjectConfigs() map[string]JenkinsProjectConfig {
+	jenkinsProjectConfigMu.RLock()
+	defer jenkinsProjectConfigMu.RUnlock()
+	return jenkinsProjectConfigGrp
+}
+
+// projectConfigStatus is the outcome of the last project config load and the time of the last
+// successful one, reported by readiness.
+var projectConfigStatus struct {
+	sync.Mutex
+	err      error
+	loadedAt time.Time
+}
+
+// loadJenkinsProjectConfig loads the project config file and the files of its include directory.
+// If they cannot be loaded or are invalid the projects are left unchanged and the error is
+// returned and kept for readiness.
+func loadJenkinsProjectConfig(filename string) (err error) {
+	defer func() {
+		projectConfigStatus.Lock()
+		defer projectConfigStatus.Unlock()
+		projectConfigStatus.err = err
+		if err == nil {
+			projectConfigStatus.loadedAt = time.Now()
+		}
+	}()
+	grp, problems, err := readJenkinsProjectConfig(filename)
+	if err != nil {
+		return fmt.Errorf("load jenkins project config %s: %v", filename, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid jenkins project config: %s", strings.Join(problems, "; "))
+	}
+	for _, warning := range checkJenkinsProjectWarnings(grp) {
+		logger.Info("jenkins project config warning", "warning", warning)
+	}
+	// Profiles are shared by entries, their references are resolved once.
+	resolved := make(map[string]string)
+	resolve := func(value string) (string, error) {
+		secret, ok := resolved[value]
+		if !ok {
+			if secret, err = resolveSecret(value); err != nil {
+				return "", err
+			}
+			resolved[value] = secret
+		}
+		return secret, nil
+	}
+	for name, config := range grp {
+		if config.JenkinsToken, err = resolve(config.JenkinsToken); err == nil {
+			if config.JenkinsUserApiToken, err = resolve(config.JenkinsUserApiToken); err == nil {
+				config.Server.UserApiToken, err = resolve(config.Server.UserApiToken)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("load jenkins project config: %s: entry %s: %v", config.Source, name, err)
+		}
+		registerSecrets(config.JenkinsToken, config.JenkinsUserApiToken, config.Server.UserApiToken)
+		grp[name] = config
+	}
+	jenkinsProjectConfigMu.Lock()
+	jenkinsProjectConfigGrp = grp
+	jenkinsProjectConfigMu.Unlock()
+	return nil
+}
+
+// projectIncludeDir returns the directory of per-team files merged into a project config file,
+// e.g. projects.d for projects.yaml.
+func projectIncludeDir(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".d"
+}
+
+// projectConfigFiles returns the project config file followed by the .yaml and .yml files of its
+// include directory in name order. The include directory is optional.
+func projectConfigFiles(filename string) ([]string, error) {
+	files := []string{filename}
+	dir := projectIncludeDir(filename)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return files, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ext := entry.Name(), filepath.Ext(entry.Name())
+		if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// readJenkinsProjectConfig reads a project config file and its include directory and merges their
+// entries and profiles, every problem found is returned prefixed by its file. An entry or profile
+// defined in two files and entries of different files matching the same hooks are problems too.
+// Each entry is given its profile.
+func readJenkinsProjectConfig(filename string) (map[string]JenkinsProjectConfig, []string, error) {
+	files, err := projectConfigFiles(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	grp := make(map[string]JenkinsProjectConfig)
+	servers := make(map[string]JenkinsServerConfig)
+	serverSources := make(map[string]string)
+	var problems []string
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries, fileServers, fileProblems := decodeJenkinsProjectEntries(b)
+		for _, problem := range fileProblems {
+			problems = append(problems, file+": "+problem)
+		}
+		for _, name := range sortedServerNames(fileServers) {
+			if source, ok := serverSources[name]; ok {
+				problems = append(problems, fmt.Sprintf("%s: jenkins server %s is already defined in %s", file, name, source))
+				continue
+			}
+			server := fileServers[name]
+			if server.CaFile != "" {
+				if problem := readJenkinsServerCaFile(&server); problem != "" {
+					problems = append(problems, fmt.Sprintf("%s: jenkins server %s: %s", file, name, problem))
+				}
+			}
+			servers[name], serverSources[name] = server, file
+		}
+		for _, name := range sortedProjectNames(entries) {
+			if existing, ok := grp[name]; ok {
+				problems = append(problems, fmt.Sprintf("%s: entry %s is already defined in %s", file, name, existing.Source))
+				continue
+			}
+			config := entries[name]
+			config.Source = file
+			grp[name] = config
+		}
+	}
+	if len(grp) == 0 && len(problems) == 0 {
+		problems = append(problems, filename+": no project entries")
+	}
+	for _, name := range sortedProjectNames(grp) {
+		config := grp[name]
+		if config.JenkinsServer != "" && config.JenkinsServer != defaultJenkinsServer {
+			server, ok := servers[config.JenkinsServer]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: entry %s: unknown jenkins_server %s", config.Source, name, config.JenkinsServer))
+				continue
+			}
+			config.Server = server
+			grp[name] = config
+		}
+	}
+	return grp, append(problems, checkJenkinsProjectTuples(grp)...), nil
+}
+
+// checkJenkinsProjectWarnings reports the entries replacing the host of a profile with credentials
+// without their own. They are valid, but notify their host without credentials as the profile's
+// are never sent to another host.
+func checkJenkinsProjectWarnings(grp map[string]JenkinsProjectConfig) []string {
+	var warnings []string
+	for _, name := range sortedProjectNames(grp) {
+		config := grp[name]
+		server, base := JenkinsServerConfig{Username: settings.jenkinsUserName}, defaultJenkinsServer
+		if config.JenkinsServer != "" && config.JenkinsServer != defaultJenkinsServer {
+			server, base = config.Server, config.JenkinsServer
+		}
+		if config.JenkinsHost != "" && config.JenkinsUsername == "" && server.Username != "" {
+			warnings = append(warnings, fmt.Sprintf("%s: entry %s: jenkins_host replaces the host of jenkins server %s "+
+				"without jenkins_username and jenkins_user_api_token, it is notified without credentials", config.Source, name, base))
+		}
+	}
+	return warnings
+}
+
+// readJenkinsServerCaFile reads the CA file of a profile, it returns a problem if the file cannot
+// be read or has no certificate.
+func readJenkinsServerCaFile(server *JenkinsServerConfig) string {
+	b, err := ioutil.ReadFile(server.CaFile)
+	if err != nil {
+		return "ca_file: " + err.Error()
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(b) {
+		return "ca_file: no PEM certificate in " + server.CaFile
+	}
+	server.caCerts = string(b)
+	return ""
+}
+
+func sortedServerNames(servers map[string]JenkinsServerConfig) []string {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedProjectNames(grp map[string]JenkinsProjectConfig) []string {
+	names := make([]string, 0, len(grp))
+	for name := range grp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var unknownFieldPattern = regexp.MustCompile(` not found in type main\.\w+$`)
+
+// decodeJenkinsProjectEntries decodes the entries and the profiles of a project config file and
+// checks each one. Unknown keys, missing required fields, a negative debounce_seconds and secret
+// references to unknown providers are problems. Secret references are not resolved.
+func decodeJenkinsProjectEntries(b []byte) (map[string]JenkinsProjectConfig, map[string]JenkinsServerConfig, []string) {
+	var file jenkinsProjectFile
+	var problems []string
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, nil, []string{err.Error()}
+		}
+		for _, e := range typeErr.Errors {
+			problems = append(problems, unknownFieldPattern.ReplaceAllString(e, " is unknown"))
+		}
+	}
+	grp := file.Entries
+
+	for _, name := range sortedServerNames(file.JenkinsServers) {
+		server := file.JenkinsServers[name]
+		if name == defaultJenkinsServer {
+			problems = append(problems, "jenkins server default is made of the -jenkins-* flags and cannot be defined")
+			continue
+		}
+		var missing []string
+		if server.Host == "" {
+			missing = append(missing, "host")
+		}
+		if server.Url == "" {
+			missing = append(missing, "url")
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("jenkins server %s: missing %s", name, strings.Join(missing, ", ")))
+		}
+		if (server.Username == "") != (server.UserApiToken == "") {
+			problems = append(problems, fmt.Sprintf("jenkins server %s: username and user_api_token go together", name))
+		}
+		if err := checkSecretReference(server.UserApiToken); err != nil {
+			problems = append(problems, fmt.Sprintf("jenkins server %s: user_api_token: %v", name, err))
+		}
+	}
+
+	for _, name := range sortedProjectNames(grp) {
+		config := grp[name]
+		var missing []string
+		for _, field := range []struct{ name, value string }{
+			{"environment", config.Environment},
+			{"vcs_project", config.VcsProject},
+			{"branch", config.Branch},
+			{"jenkins_project", config.JenkinsProject},
+			{"jenkins_token", config.JenkinsToken},
+		} {
+			if field.value == "" {
+				missing = append(missing, field.name)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("entry %s: missing %s", name, strings.Join(missing, ", ")))
+		}
+		if config.DebounceSeconds < 0 {
+			problems = append(problems, fmt.Sprintf("entry %s: debounce_seconds is negative", name))
+		}
+		if (config.JenkinsUsername == "") != (config.JenkinsUserApiToken == "") {
+			problems = append(problems, fmt.Sprintf("entry %s: jenkins_username and jenkins_user_api_token go together", name))
+		}
+
+		for _, field := range []struct{ name, value string }{
+			{"jenkins_token", config.JenkinsToken},
+			{"jenkins_user_api_token", config.JenkinsUserApiToken},
+		} {
+			if err := checkSecretReference(field.value); err != nil {
+				problems = append(problems, fmt.Sprintf("entry %s: %s: %v", name, field.name, err))
+			}
+		}
+	}
+	return grp, file.JenkinsServers, problems
 }
 
-var jenkinsProjectConfigGrp map[string]JenkinsProjectConfig
+// checkJenkinsProjectTuples reports entries matching the same environment, vcs_project and branch,
+// which would be chosen at random. They are duplicate if they are the same apart from their file.
+func checkJenkinsProjectTuples(grp map[string]JenkinsProjectConfig) []string {
+	var problems []string
+	tuples := make(map[[3]string][]string)
+	var tupleOrder [][3]string
+	for _, name := range sortedProjectNames(grp) {
+		config := grp[name]
+		tuple := [3]string{config.Environment, config.VcsProject, config.Branch}
+		if len(tuples[tuple]) == 0 {
+			tupleOrder = append(tupleOrder, tuple)
+		}
+		tuples[tuple] = append(tuples[tuple], name)
+	}
+	for _, tuple := range tupleOrder {
+		entries := tuples[tuple]
+		if len(entries) < 2 {
+			continue
+		}
+		kind := "duplicate"
+		first := grp[entries[0]]
+		first.Source = ""
+		var origins []string
+		for _, name := range entries {
+			config := grp[name]
+			origins = append(origins, fmt.Sprintf("%s (%s)", name, config.Source))
+			if config.Source = ""; config != first {
+				kind = "ambiguous"
+			}
+		}
+		problems = append(problems, fmt.Sprintf("entries %s are %s, they all match environment=%s vcs_project=%s branch=%s",
+			strings.Join(origins, ", "), kind, tuple[0], tuple[1], tuple[2]))
+	}
+	return problems
+}
 
-func loadJenkinsProjectConfig(filename string) {
-	cl.LoadByFile(filename, &jenkinsProjectConfigGrp)
+// runValidateConfig is the validate-config subcommand, it exits non-zero if the project config
+// is invalid. For a valid config it prints the resolved Jenkins settings of every entry, the
+// default profile is given by the -jenkins-* flags. -notification-config-file checks a
+// notification config along with it.
+func runValidateConfig(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	registerJenkinsFlags(flags)
+	notificationConfigFile := flags.String("notification-config-file", "", "Notification config file to check as well.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: prcd validate-config [options] <project config file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	grp, problems, err := readJenkinsProjectConfig(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%s is invalid: %d problems\n", flags.Arg(0), len(problems))
+		return 1
+	}
+	if *notificationConfigFile != "" {
+		channels, problems, err := readNotificationConfig(*notificationConfigFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%s is invalid: %d problems\n", *notificationConfigFile, len(problems))
+			return 1
+		}
+		fmt.Printf("%s is valid: %d channels\n", *notificationConfigFile, len(channels))
+	}
+	for _, warning := range checkJenkinsProjectWarnings(grp) {
+		fmt.Println("warning:", warning)
+	}
+	for _, name := range sortedProjectNames(grp) {
+		fmt.Printf("entry %s: %s\n", name, formatJenkinsSettings(createNotifier(grp[name].jenkinsProject()).jenkinsSettings()))
+	}
+	fmt.Printf("%s is valid: %d entries\n", flags.Arg(0), len(grp))
+	return 0
+}
+
+// unmatchedReason explains why matchJenkinsProject found no usable entry for a hook.
+func unmatchedReason(environment, project, branch string) string {
+	projectEntries, environmentEntries := 0, 0
+	for name, config := range jenkinsProjectConfigs() {
+		if config.VcsProject != project {
+			continue
+		}
+		projectEntries++
+		if config.Environment != environment {
+			continue
+		}
+		environmentEntries++
+		if config.Branch == branch {
+			return fmt.Sprintf("entry %s has no jenkins_project or jenkins_token", name)
+		}
+	}
+	switch {
+	case projectEntries == 0:
+		return fmt.Sprintf("no entry for vcs_project %s", project)
+	case environmentEntries == 0:
+		return fmt.Sprintf("no entry for vcs_project %s in environment %s", project, environment)
+	default:
+		return fmt.Sprintf("no entry for branch %s of vcs_project %s in environment %s", branch, project, environment)
+	}
 }
 
 func matchJenkinsProject(environment, project, branch string) JenkinsProject {
-	for _, config := range jenkinsProjectConfigGrp {
+	for name, config := range jenkinsProjectConfigs() {
 		if config.Environment == environment && config.VcsProject == project && config.Branch == branch {
-			return JenkinsProject{
-				Name:         config.JenkinsProject,
-				Token:        config.JenkinsToken,
-				Host:         config.JenkinsHost,
-				Url:          config.JenkinsUrl,
-				Username:     config.JenkinsUsername,
-				UserApiToken: config.JenkinsUserApiToken,
-			}
+			jenkinsProject := config.jenkinsProject()
+			jenkinsProject.Entry = name
+			return jenkinsProject
 		}
 	}
 	return JenkinsProject{}
 }
+
+// jenkinsProject returns the project notified for the hooks matching the entry.
+func (config JenkinsProjectConfig) jenkinsProject() JenkinsProject {
+	project := JenkinsProject{
+		Name:         config.JenkinsProject,
+		Token:        config.JenkinsToken,
+		Host:         config.JenkinsHost,
+		Url:          config.JenkinsUrl,
+		Username:     config.JenkinsUsername,
+		UserApiToken: config.JenkinsUserApiToken,
+		DryRun:       config.DryRun,
+		Debounce:     time.Duration(config.DebounceSeconds) * time.Second,
+		Source:       config.Source,
+	}
+	if config.JenkinsServer != defaultJenkinsServer {
+		project.Server, project.ServerConfig = config.JenkinsServer, config.Server
+	}
+	return project
+}
@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestJenkinsProjectConfigParsing(t *testing.T) {
 	loadJenkinsProjectConfig("config/projects.sample.yaml")
@@ -35,7 +43,7 @@ func TestJenkinsProjectConfigParsing(t *testing.T) {
 	}
 	if jenkinsProjectConfigGrp["dev-backend-dependent"].Environment != "debug" ||
 		jenkinsProjectConfigGrp["dev-backend-dependent"].VcsProject != "mimixiche-backend" ||
-		jenkinsProjectConfigGrp["dev-backend-dependent"].Branch != "develop" ||
+		jenkinsProjectConfigGrp["dev-backend-dependent"].Branch != "develop-dependent" ||
 		jenkinsProjectConfigGrp["dev-backend-dependent"].JenkinsProject != "dev-jenkins-project" ||
 		jenkinsProjectConfigGrp["dev-backend-dependent"].JenkinsToken != "abcdefg1234" ||
 		jenkinsProjectConfigGrp["dev-backend-dependent"].JenkinsHost != "http://project-jenkins.com" ||
@@ -76,3 +84,318 @@ func TestMatchJenkinsProject(t *testing.T) {
 		t.Errorf("Jenkins project error, expected %s, actual %s.", expected, jenkinsProject.Name)
 	}
 }
+
+// readTestProjectConfig writes a project config file and reads it with its include directory.
+func readTestProjectConfig(t *testing.T, filename, content string) (map[string]JenkinsProjectConfig, []string) {
+	if err := ioutil.WriteFile(filename, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	grp, problems, err := readJenkinsProjectConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grp, problems
+}
+
+func TestReadJenkinsProjectConfig(t *testing.T) {
+	if _, problems, err := readJenkinsProjectConfig("config/projects.sample.yaml"); err != nil || len(problems) > 0 {
+		t.Errorf("The sample config should be valid, actual %v %v", problems, err)
+	}
+
+	invalid := `
+dev-backend:
+  environment: debug
+  vcs_project: mingdao
+  branch: develop
+  jenkins_project: dev
+  jenkins_tokn: abcd1234
+dev-backend-copy:
+  environment: debug
+  vcs_project: mingdao
+  branch: develop
+  jenkins_project: dev-copy
+  jenkins_token: abcd1234
+  jenkins_host: http://project-jenkins.com
+release-backend:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+release-backend-again:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+`
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	_, problems := readTestProjectConfig(t, filename, invalid)
+	expected := []string{
+		filename + ": line 7: field jenkins_tokn is unknown",
+		filename + ": entry dev-backend: missing jenkins_token",
+		"entries dev-backend (" + filename + "), dev-backend-copy (" + filename + ") are ambiguous",
+		"entries release-backend (" + filename + "), release-backend-again (" + filename + ") are duplicate",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, actual %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("Expected problem %q, actual %q", expected[i], problem)
+		}
+	}
+
+	if _, problems := readTestProjectConfig(t, filename, "dev-backend: ["); len(problems) != 1 {
+		t.Errorf("A YAML syntax error should be reported, actual %v", problems)
+	}
+	if _, problems := readTestProjectConfig(t, filename, ""); len(problems) != 1 {
+		t.Errorf("An empty config should be reported, actual %v", problems)
+	}
+
+	unpaired := "release-backend:\n  environment: production\n  vcs_project: mingdao\n  branch: master\n" +
+		"  jenkins_project: pro\n  jenkins_token: abcd1234\n  jenkins_username: deployer\n"
+	if _, problems := readTestProjectConfig(t, filename, unpaired); len(problems) != 1 ||
+		!strings.HasSuffix(problems[0], "entry release-backend: jenkins_username and jenkins_user_api_token go together") {
+		t.Errorf("A jenkins_username without jenkins_user_api_token should be reported, actual %v", problems)
+	}
+
+	debounced := "release-backend:\n  environment: production\n  vcs_project: mingdao\n  branch: master\n" +
+		"  jenkins_project: pro\n  jenkins_token: abcd1234\n  debounce_seconds: %d\n"
+	if _, problems := readTestProjectConfig(t, filename, fmt.Sprintf(debounced, -1)); len(problems) != 1 ||
+		!strings.HasSuffix(problems[0], "entry release-backend: debounce_seconds is negative") {
+		t.Errorf("A negative debounce_seconds should be reported, actual %v", problems)
+	}
+	if config, problems := readTestProjectConfig(t, filename, fmt.Sprintf(debounced, 90)); len(problems) > 0 ||
+		config["release-backend"].jenkinsProject().Debounce != 90*time.Second {
+		t.Errorf("debounce_seconds should set the debounce of the project, actual %v", problems)
+	}
+}
+
+func TestReadJenkinsProjectConfig_IncludeDir(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "projects.yaml")
+	includeDir := filepath.Join(dir, "projects.d")
+	os.Mkdir(includeDir, 0750)
+	sample, _ := ioutil.ReadFile("config/projects.sample.yaml")
+	team := filepath.Join(includeDir, "mingdao.yaml")
+	ioutil.WriteFile(team, []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+`), 0640)
+	ioutil.WriteFile(filepath.Join(includeDir, ".mingdao.yaml.swp"), []byte("not yaml: ["), 0640)
+	ioutil.WriteFile(filepath.Join(includeDir, "README"), []byte("not yaml: ["), 0640)
+
+	grp, problems := readTestProjectConfig(t, filename, string(sample))
+	if len(problems) > 0 || len(grp) != 5 {
+		t.Fatalf("The include directory should be merged, actual %v %v", problems, grp)
+	}
+	if grp["release-mingdao"].Source != team || grp["dev-backend"].Source != filename {
+		t.Errorf("Entries should keep their source file, actual %s, %s", grp["release-mingdao"].Source, grp["dev-backend"].Source)
+	}
+
+	other := filepath.Join(includeDir, "other-team.yml")
+	ioutil.WriteFile(other, []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: release
+  jenkins_project: pro
+  jenkins_token: abcd1234
+dev-backend-hijack:
+  environment: debug
+  vcs_project: mimixiche-backend
+  branch: develop
+  jenkins_project: hijack
+  jenkins_token: abcd1234
+`), 0640)
+	_, problems = readTestProjectConfig(t, filename, string(sample))
+	expected := []string{
+		other + ": entry release-mingdao is already defined in " + team,
+		"entries dev-backend (" + filename + "), dev-backend-hijack (" + other + ") are ambiguous",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, actual %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("Expected problem %q, actual %q", expected[i], problem)
+		}
+	}
+}
+
+func TestLoadJenkinsProjectConfig_Invalid(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	ioutil.WriteFile(filename, []byte("dev-backend:\n  environment: debug\n"), 0640)
+	if err := loadJenkinsProjectConfig(filename); err == nil || !strings.Contains(err.Error(), "missing vcs_project") {
+		t.Errorf("An invalid config should fail to load, actual %v", err)
+	}
+	if len(jenkinsProjectConfigGrp) != 4 {
+		t.Errorf("An invalid config should leave the projects unchanged")
+	}
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	if code := runValidateConfig([]string{"config/projects.sample.yaml"}); code != 0 {
+		t.Errorf("validate-config should succeed on the sample config, actual exit code %d", code)
+	}
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	ioutil.WriteFile(filename, []byte("dev-backend:\n  environmnet: debug\n"), 0640)
+	if code := runValidateConfig([]string{filename}); code != 1 {
+		t.Errorf("validate-config should fail on an invalid config, actual exit code %d", code)
+	}
+	if code := runValidateConfig(nil); code != 2 {
+		t.Errorf("validate-config should require a file, actual exit code %d", code)
+	}
+
+	saved := settings
+	defer func() { settings = saved }()
+	ioutil.WriteFile(filename, []byte("release-backend:\n  environment: production\n  vcs_project: mingdao\n"+
+		"  branch: master\n  jenkins_project: pro\n  jenkins_token: abcd1234\n  jenkins_host: http://evil.example.com\n"), 0640)
+	if code := runValidateConfig([]string{filename}); code != 0 {
+		t.Errorf("validate-config should accept another host without default credentials, actual exit code %d", code)
+	}
+	if code := runValidateConfig([]string{"-jenkins-user-name", "admin", "-jenkins-api-token", "s3cr3t", filename}); code != 0 {
+		t.Errorf("validate-config should only warn on another host without its own credentials, actual exit code %d", code)
+	}
+	grp, problems, _ := readJenkinsProjectConfig(filename)
+	if warnings := checkJenkinsProjectWarnings(grp); len(problems) != 0 || len(warnings) != 1 ||
+		!strings.Contains(warnings[0], "entry release-backend: jenkins_host replaces the host of jenkins server default") {
+		t.Errorf("Another host without its own credentials should be a warning, actual %v %v", problems, warnings)
+	}
+	if err := loadJenkinsProjectConfig(filename); err != nil {
+		t.Errorf("Another host without its own credentials should not block the load, actual %v", err)
+	}
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+}
+
+func TestReadJenkinsProjectConfig_JenkinsServers(t *testing.T) {
+	config := `
+jenkins_servers:
+  ci-prod:
+    host: https://ci-prod.example.com
+    url: /job/<project>/build?token=<token>
+    username: deployer
+    user_api_token: s3cr3t
+    timeout: 30s
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+  jenkins_server: ci-prod
+dev-mingdao:
+  environment: debug
+  vcs_project: mingdao
+  branch: develop
+  jenkins_project: dev
+  jenkins_token: abcd1234
+  jenkins_server: default
+`
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	grp, problems := readTestProjectConfig(t, filename, config)
+	if len(problems) > 0 {
+		t.Fatalf("The config should be valid, actual %v", problems)
+	}
+	expected := JenkinsServerConfig{Host: "https://ci-prod.example.com", Url: "/job/<project>/build?token=<token>",
+		Username: "deployer", UserApiToken: "s3cr3t", Timeout: 30 * time.Second}
+	if project := grp["release-mingdao"].jenkinsProject(); project.Server != "ci-prod" || project.ServerConfig != expected {
+		t.Errorf("The entry should use its profile, actual %+v", project)
+	}
+	if project := grp["dev-mingdao"].jenkinsProject(); project.Server != "" {
+		t.Errorf("The default profile should be the -jenkins-* flags, actual %+v", project)
+	}
+
+	invalid := `
+jenkins_servers:
+  default:
+    host: https://ci.example.com
+    url: /job/<project>/build?token=<token>
+  ci-prod:
+    host: https://ci-prod.example.com
+    username: deployer
+    ca_file: /not/exists.pem
+    retries: 3
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+  jenkins_server: ci-staging
+dev-mingdao:
+  environment: debug
+  vcs_project: mingdao
+  branch: develop
+  jenkins_project: dev
+  jenkins_token: abcd1234
+  jenkins_server: ci-prod
+  jenkins_host: http://project-jenkins.com
+  jenkins_url: /job/<project>/build?token=<token>
+  jenkins_username: akimimi
+  jenkins_user_api_token: akimimi
+`
+	_, problems = readTestProjectConfig(t, filename, invalid)
+	expectedProblems := []string{
+		filename + ": line 10: field retries is unknown",
+		filename + ": jenkins server ci-prod: missing url",
+		filename + ": jenkins server ci-prod: username and user_api_token go together",
+		filename + ": jenkins server default is made of the -jenkins-* flags",
+		filename + ": jenkins server ci-prod: ca_file: open /not/exists.pem",
+		filename + ": entry release-mingdao: unknown jenkins_server ci-staging",
+	}
+	if len(problems) != len(expectedProblems) {
+		t.Fatalf("Expected %d problems, actual %v", len(expectedProblems), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expectedProblems[i]) {
+			t.Errorf("Expected problem %q, actual %q", expectedProblems[i], problem)
+		}
+	}
+}
+
+func TestResolveJenkinsServer(t *testing.T) {
+	defaults := JenkinsServerConfig{Host: "http://cd.example.com", Url: "/job/<project>/build?token=<token>",
+		Username: "prcd", UserApiToken: "default-s3cr3t"}
+	profile := JenkinsServerConfig{Host: "https://ci-prod.example.com", Url: "/job/<project>/buildWithParameters?token=<token>",
+		Username: "deployer", UserApiToken: "profile-s3cr3t", Timeout: time.Minute}
+
+	server, fields := resolveJenkinsServer(JenkinsProject{Url: "/job/<project>/buildWithParameters?token=<token>"}, defaults)
+	expected := defaults
+	expected.Url = "/job/<project>/buildWithParameters?token=<token>"
+	if server != expected {
+		t.Errorf("Only the url should be taken from the entry, actual %+v", server)
+	}
+	if actual := formatJenkinsSettings(fields); actual != `host="http://cd.example.com" (default) `+
+		`url="/job/<project>/buildWithParameters?token=<token>" (entry) username="prcd" (default) user_api_token="******" (default)` {
+		t.Errorf("The settings should be reported with their sources, actual %s", actual)
+	}
+
+	server, fields = resolveJenkinsServer(JenkinsProject{Host: "http://evil.example.com"}, defaults)
+	expected = JenkinsServerConfig{Host: "http://evil.example.com", Url: defaults.Url}
+	if server != expected {
+		t.Errorf("An entry replacing the host should not get the default credentials, actual %+v", server)
+	}
+	if fields[2].Source != credentialsNotInherited || fields[3].Source != credentialsNotInherited {
+		t.Errorf("The credentials should be reported as not inherited, actual %s", formatJenkinsSettings(fields))
+	}
+
+	server, fields = resolveJenkinsServer(JenkinsProject{Server: "ci-prod", ServerConfig: profile, Username: "akimimi",
+		UserApiToken: "entry-s3cr3t"}, defaults)
+	expected = profile
+	expected.Username, expected.UserApiToken = "akimimi", "entry-s3cr3t"
+	if server != expected {
+		t.Errorf("The profile should be the base of the entry, actual %+v", server)
+	}
+	for i, source := range []string{"ci-prod", "ci-prod", "entry", "entry"} {
+		if fields[i].Source != source {
+			t.Errorf("%s should come from %s, actual %s", fields[i].Name, source, fields[i].Source)
+		}
+	}
+}
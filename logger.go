@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the message log.
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// Log levels, a record is written if its level is not above logLevel.
+const (
+	LevelError = iota
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"Error", "Info", "Debug"}
+
+var (
+	logOutput io.Writer = os.Stdout
+	logLevel            = LevelDebug
+	logFormat           = LogFormatText
+	logMu     sync.Mutex
+)
+
+// correlationHeaders are provider delivery headers whose value is used as correlation id.
+var correlationHeaders = []string{"X-GitHub-Delivery", "X-Gitlab-Event-UUID", "X-Request-Id"}
+
+// Logger writes log records with the correlation id of a hook delivery, fields follow the message
+// as key-value pairs. The zero Logger writes records without correlation id.
+type Logger struct {
+	CorrelationId string
+}
+
+// logger is the Logger for records not related to a hook delivery.
+var logger Logger
+
+// Debug writes a debug record, it is only written with -verbose.
+func (l Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info writes an info record.
+func (l Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Error writes an error record.
+func (l Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l Logger) log(level int, msg string, keyvals []interface{}) {
+	if level > logLevel {
+		return
+	}
+	caller := ""
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	record := formatLogRecord(time.Now(), level, caller, l.CorrelationId, redactString(msg), redactLogValues(keyvals))
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput.Write(record)
+}
+
+// formatLogRecord formats a record as one line of text or JSON. JSON records always start with the
+// time, level, caller, correlation_id (if any) and msg fields.
+func formatLogRecord(t time.Time, level int, caller, correlationId, msg string, keyvals []interface{}) []byte {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "")
+	}
+	var buf bytes.Buffer
+	if logFormat == LogFormatJson {
+		buf.WriteString(`{"time":`)
+		writeJsonValue(&buf, t.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJsonValue(&buf, strings.ToLower(levelNames[level]))
+		buf.WriteString(`,"caller":`)
+		writeJsonValue(&buf, caller)
+		if correlationId != "" {
+			buf.WriteString(`,"correlation_id":`)
+			writeJsonValue(&buf, correlationId)
+		}
+		buf.WriteString(`,"msg":`)
+		writeJsonValue(&buf, msg)
+		for i := 0; i < len(keyvals); i += 2 {
+			buf.WriteByte(',')
+			writeJsonValue(&buf, fmt.Sprint(keyvals[i]))
+			buf.WriteByte(':')
+			writeJsonValue(&buf, keyvals[i+1])
+		}
+		buf.WriteString("}\n")
+		return buf.Bytes()
+	}
+
+	buf.WriteString(t.Format("2006/01/02 15:04:05"))
+	buf.WriteString(" [" + caller + "] [" + levelNames[level] + "] " + msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		buf.WriteString(" " + fmt.Sprint(keyvals[i]) + "=" + quoteLogValue(keyvals[i+1]))
+	}
+	if correlationId != "" {
+		buf.WriteString(" correlation_id=" + correlationId)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// redactLogValues masks the secrets in string and error values, other values are kept as is.
+func redactLogValues(keyvals []interface{}) []interface{} {
+	redacted := make([]interface{}, len(keyvals))
+	for i, v := range keyvals {
+		switch value := v.(type) {
+		case string:
+			redacted[i] = redactString(value)
+		case error:
+			redacted[i] = redactString(value.Error())
+		default:
+			redacted[i] = v
+		}
+	}
+	return redacted
+}
+
+func writeJsonValue(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+func quoteLogValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// setupMessageLog writes log records to the console and appends them to the message log file.
+func setupMessageLog(filename, format string, verbose bool, rotation LogRotation) error {
+	if format != LogFormatText && format != LogFormatJson {
+		return fmt.Errorf("unknown log format %s", format)
+	}
+	f, err := openRotatingFile(filename, rotation)
+	if err != nil {
+		return err
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput, logFormat, logLevel = io.MultiWriter(os.Stdout, f), format, LevelInfo
+	if verbose {
+		logLevel = LevelDebug
+	}
+	return nil
+}
+
+// newCorrelationId returns the delivery id from the provider headers, or a random id if none of
+// the headers is present.
+func newCorrelationId(header func(string) string) string {
+	for _, name := range correlationHeaders {
+		if id := strings.TrimSpace(header(name)); id != "" {
+			return id
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
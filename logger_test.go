@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogap/errors"
+)
+
+func TestFormatLogRecord_Text(t *testing.T) {
+	logFormat = LogFormatText
+	at := time.Date(2021, 7, 23, 17, 48, 28, 0, time.Local)
+	record := string(formatLogRecord(at, LevelInfo, "prcd.go:10", "abc", "hook parsed",
+		[]interface{}{"project", "mingdao", "title", "fix paging", "odd"}))
+	expected := `2021/07/23 17:48:28 [prcd.go:10] [Info] hook parsed project=mingdao title="fix paging" odd="" correlation_id=abc` + "\n"
+	if record != expected {
+		t.Errorf("Text record error, expected %s, actual %s", expected, record)
+	}
+}
+
+func TestFormatLogRecord_Json(t *testing.T) {
+	logFormat = LogFormatJson
+	defer func() { logFormat = LogFormatText }()
+	at := time.Date(2021, 7, 23, 17, 48, 28, 0, time.UTC)
+	record := formatLogRecord(at, LevelError, "prcd.go:10", "abc", "notify jenkins failed",
+		[]interface{}{"status", 500, "error", errors.New("boom")})
+	if !bytes.HasPrefix(record, []byte(`{"time":"2021-07-23T17:48:28Z","level":"error","caller":"prcd.go:10","correlation_id":"abc","msg":"notify jenkins failed"`)) {
+		t.Errorf("JSON record should start with the stable fields, actual %s", record)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(record, &fields); err != nil {
+		t.Fatalf("JSON record should be valid JSON, %s", err)
+	}
+	if fields["status"] != float64(500) || fields["error"] != "boom" {
+		t.Errorf("JSON record fields error, actual %v", fields)
+	}
+
+	record = formatLogRecord(at, LevelInfo, "", "", "listening", nil)
+	if strings.Contains(string(record), "correlation_id") {
+		t.Errorf("Record without correlation id should omit it, actual %s", record)
+	}
+}
+
+func TestLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	output, level := logOutput, logLevel
+	defer func() { logOutput, logLevel = output, level }()
+	logOutput, logLevel = &buf, LevelInfo
+
+	log := Logger{CorrelationId: "abc"}
+	log.Debug("hidden")
+	log.Info("shown", "k", "v")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "[logger_test.go:") ||
+		!strings.Contains(buf.String(), "shown k=v correlation_id=abc") {
+		t.Errorf("Logger output error, actual %s", buf.String())
+	}
+}
+
+func TestNewCorrelationId(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	if id := newCorrelationId(header.Get); id != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+		t.Errorf("Correlation id should come from the delivery header, actual %s", id)
+	}
+	a, b := newCorrelationId(http.Header{}.Get), newCorrelationId(http.Header{}.Get)
+	if len(a) != 32 || a == b {
+		t.Errorf("Generated correlation ids should be random, actual %s %s", a, b)
+	}
+}
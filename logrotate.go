@@ -0,0 +1,260 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rotatedTimeFormat is the suffix of rotated log files, it sorts in rotation order. Rotations
+// within the same millisecond are named by the next free millisecond.
+const rotatedTimeFormat = "20060102-150405.000"
+
+// LogRotation defines when a log file is rotated and which rotated files are kept. Zero fields
+// disable the corresponding rule.
+type LogRotation struct {
+	MaxSize    int64
+	Interval   time.Duration
+	Compress   bool
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// rotatingFile appends to a log file and rotates it by size or time. Rotated files are renamed
+// with a timestamp suffix, then gzipped and removed by count and age in the background, one
+// rotation at a time. Rotation errors are written to stderr, the message log may be the file being
+// rotated. A failed rotation keeps appending to the log file.
+type rotatingFile struct {
+	mu       sync.Mutex
+	filename string
+	rotation LogRotation
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	housekeepingMu sync.Mutex
+	housekeeping   sync.WaitGroup
+}
+
+var (
+	rotatingFiles   []*rotatingFile
+	rotatingFilesMu sync.Mutex
+)
+
+// openRotatingFile opens filename for appending, the file is reopened by reopenLogFiles.
+func openRotatingFile(filename string, rotation LogRotation) (*rotatingFile, error) {
+	f := &rotatingFile{filename: filename, rotation: rotation}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	rotatingFilesMu.Lock()
+	defer rotatingFilesMu.Unlock()
+	rotatingFiles = append(rotatingFiles, f)
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), time.Now()
+	// An existing log is aged from its last write, so an interval rotation is not postponed by
+	// every restart.
+	if f.size > 0 && info.ModTime().Before(f.openedAt) {
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would exceed the size limit or the rotation
+// interval has passed.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil && f.size > 0 && (f.rotation.MaxSize > 0 && f.size+int64(len(p)) > f.rotation.MaxSize ||
+		f.rotation.Interval > 0 && time.Since(f.openedAt) >= f.rotation.Interval) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotate log %s failed: %s\n", f.filename, err)
+		}
+	}
+	// The file is closed if it could not be opened again by a rotation or a reopen.
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file, after it was moved away by an external logrotate.
+func (f *rotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the file after the background compression of its rotated files, it is no longer
+// reopened.
+func (f *rotatingFile) Close() error {
+	rotatingFilesMu.Lock()
+	for i, opened := range rotatingFiles {
+		if opened == f {
+			rotatingFiles = append(rotatingFiles[:i], rotatingFiles[i+1:]...)
+			break
+		}
+	}
+	rotatingFilesMu.Unlock()
+	f.housekeeping.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate moves the file away and opens a new one. If the file cannot be moved, it is opened again
+// under its name; if the new one cannot be opened, the next Write retries.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	rotated := f.rotatedName()
+	if err := os.Rename(f.filename, rotated); err != nil {
+		if e := f.open(); e != nil {
+			return fmt.Errorf("%v, reopen: %v", err, e)
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.housekeeping.Add(1)
+	go func() {
+		defer f.housekeeping.Done()
+		f.housekeepingMu.Lock()
+		defer f.housekeepingMu.Unlock()
+		if f.rotation.Compress {
+			if err := gzipFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "compress rotated log %s failed: %s\n", rotated, err)
+			}
+		}
+		f.removeExpired()
+	}()
+	return nil
+}
+
+// rotatedName returns the name of the file rotated now, taking the next millisecond if a file of
+// this one already exists, compressed or not.
+func (f *rotatingFile) rotatedName() string {
+	for t := time.Now(); ; t = t.Add(time.Millisecond) {
+		name := f.filename + "." + t.Format(rotatedTimeFormat)
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return !os.IsNotExist(err)
+}
+
+// removeExpired removes the rotated files beyond MaxBackups or older than MaxAge.
+func (f *rotatingFile) removeExpired() {
+	matches, err := filepath.Glob(f.filename + ".*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, name := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, f.filename+"."), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	for i, name := range rotated {
+		expired := f.rotation.MaxBackups > 0 && i >= f.rotation.MaxBackups
+		if info, err := os.Stat(name); err == nil && f.rotation.MaxAge > 0 {
+			expired = expired || time.Since(info.ModTime()) > f.rotation.MaxAge
+		}
+		if expired {
+			if err := os.Remove(name); err != nil {
+				fmt.Fprintf(os.Stderr, "remove rotated log %s failed: %s\n", name, err)
+			}
+		}
+	}
+}
+
+func gzipFile(filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(filename+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// reopenLogFiles reopens every rotating log file.
+func reopenLogFiles() {
+	rotatingFilesMu.Lock()
+	defer rotatingFilesMu.Unlock()
+	for _, f := range rotatingFiles {
+		if err := f.Reopen(); err != nil {
+			logger.Error("reopen log file failed", "file", f.filename, "error", err)
+		}
+	}
+}
+
+// reopenLogFilesOnSignal reopens the log files on every SIGUSR1 received from now on.
+func reopenLogFilesOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			reopenLogFiles()
+			logger.Info("log files reopened")
+		}
+	}()
+}
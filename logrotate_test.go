@@ -0,0 +1,190 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func rotatedFiles(t *testing.T, filename string) []string {
+	matches, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestRotatingFile_AppendsOnOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hook-request.log")
+	ioutil.WriteFile(filename, []byte("before restart\n"), 0660)
+	f, err := openRotatingFile(filename, LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after restart\n"))
+	f.Close()
+	if b, _ := ioutil.ReadFile(filename); string(b) != "before restart\nafter restart\n" {
+		t.Errorf("Log file should be appended, actual %q", b)
+	}
+}
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	f, err := openRotatingFile(filename, LogRotation{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		f.Write([]byte(line))
+	}
+	f.housekeeping.Wait()
+	if b, _ := ioutil.ReadFile(filename); string(b) != "fourth\n" {
+		t.Errorf("Log file should hold the writes after the last rotation, actual %q", b)
+	}
+	rotated := rotatedFiles(t, filename)
+	if len(rotated) != 2 {
+		t.Fatalf("Rotated files should be kept by count, actual %v", rotated)
+	}
+	if b, _ := ioutil.ReadFile(rotated[1]); string(b) != "third\n" {
+		t.Errorf("The newest rotated file should hold the previous writes, actual %q", b)
+	}
+}
+
+func TestRotatingFile_RotatesByIntervalAndCompresses(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	f, err := openRotatingFile(filename, LogRotation{Interval: time.Millisecond, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("yesterday\n"))
+	time.Sleep(2 * time.Millisecond)
+	f.Write([]byte("today\n"))
+	f.housekeeping.Wait()
+
+	rotated := rotatedFiles(t, filename)
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Fatalf("The rotated file should be gzipped, actual %v", rotated)
+	}
+	gz, _ := os.Open(rotated[0])
+	defer gz.Close()
+	r, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(r); string(b) != "yesterday\n" {
+		t.Errorf("The gzipped file should hold the rotated writes, actual %q", b)
+	}
+}
+
+func TestRotatingFile_RotatesByIntervalAcrossRestarts(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	ioutil.WriteFile(filename, []byte("before restart\n"), 0660)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(filename, yesterday, yesterday)
+	f, err := openRotatingFile(filename, LogRotation{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("after restart\n"))
+	f.housekeeping.Wait()
+
+	if rotated := rotatedFiles(t, filename); len(rotated) != 1 {
+		t.Fatalf("A log last written before the interval should rotate on the first write after a restart, actual %v", rotated)
+	}
+	if b, _ := ioutil.ReadFile(filename); string(b) != "after restart\n" {
+		t.Errorf("The log should hold the writes after the rotation, actual %q", b)
+	}
+}
+
+func TestRotatingFile_RemovesByAge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	old := filename + "." + time.Now().AddDate(0, 0, -10).Format(rotatedTimeFormat)
+	ioutil.WriteFile(old, []byte("old\n"), 0660)
+	os.Chtimes(old, time.Now().AddDate(0, 0, -10), time.Now().AddDate(0, 0, -10))
+	unrelated := filename + ".bak"
+	ioutil.WriteFile(unrelated, []byte("kept\n"), 0660)
+
+	f, err := openRotatingFile(filename, LogRotation{MaxSize: 1, MaxAge: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("a\n"))
+	f.Write([]byte("b\n"))
+	f.housekeeping.Wait()
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Rotated files older than the max age should be removed")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Files not rotated by prcd should be kept")
+	}
+	if rotated, _ := filepath.Glob(filename + ".2*"); len(rotated) != 1 {
+		t.Errorf("The new rotated file should be kept, actual %v", rotated)
+	}
+}
+
+func TestRotatingFile_KeepsWritingWhenRotationFails(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	f, err := openRotatingFile(filename, LogRotation{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("removed\n"))
+	// The rename of the rotation fails as the file is gone.
+	os.Remove(filename)
+	if _, err := f.Write([]byte("after a failed rotation\n")); err != nil {
+		t.Fatalf("A failed rotation should not fail the write, actual %v", err)
+	}
+	if b, _ := ioutil.ReadFile(filename); string(b) != "after a failed rotation\n" {
+		t.Errorf("The log file should be opened again after a failed rotation, actual %q", b)
+	}
+}
+
+func TestRotatingFile_RotatedNamesAreUnique(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	f, err := openRotatingFile(filename, LogRotation{MaxSize: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 6; i++ {
+		f.Write([]byte("line\n"))
+	}
+	f.housekeeping.Wait()
+	if rotated := rotatedFiles(t, filename); len(rotated) != 5 {
+		t.Errorf("Rotations within a millisecond should not overwrite each other, actual %v", rotated)
+	}
+}
+
+func TestReopenLogFilesOnSignal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "message.log")
+	f, err := openRotatingFile(filename, LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reopenLogFilesOnSignal()
+	f.Write([]byte("before logrotate\n"))
+	os.Rename(filename, filename+".1")
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filename); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.Write([]byte("after logrotate\n"))
+	if b, _ := ioutil.ReadFile(filename); string(b) != "after logrotate\n" {
+		t.Errorf("Log file should be reopened on SIGUSR1, actual %q", b)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics of the hook pipeline, exposed on settings.metricsUrl. The label values taken
+// from hook payloads are limited to the known hooks and the configured projects and environments,
+// others are counted as otherLabel.
+var (
+	hooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prcd_hooks_received_total",
+		Help: "Hooks received, by known hook name and the agent handling them.",
+	}, []string{"hook_name", "agent"})
+
+	duplicatesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prcd_duplicate_hooks_dropped_total",
+		Help: "Identical hook payloads dropped within the dedup window.",
+	})
+
+	hookErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prcd_hook_errors_total",
+		Help: "Hooks failed to read or parse, by errcode.",
+	}, []string{"errcode"})
+
+	projectMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prcd_project_matches_total",
+		Help: "Jenkins project matching of triggering hooks, by configured project, environment and result (matched or unmatched).",
+	}, []string{"project", "environment", "result"})
+
+	jenkinsNotifies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prcd_jenkins_notify_total",
+		Help: "Jenkins notify outcomes, by response status class (2xx, 4xx, 5xx), error or dry_run.",
+	}, []string{"status_class"})
+
+	hookParseDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prcd_hook_parse_duration_seconds",
+		Help:    "Time spent parsing a hook payload by its agent.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1},
+	})
+
+	jenkinsRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prcd_jenkins_request_duration_seconds",
+		Help:    "Round trip time of Jenkins notify requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dispatchInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prcd_dispatch_in_flight",
+		Help: "Hook dispatch goroutines currently running.",
+	})
+)
+
+// otherLabel is the label value of the hook names, projects and environments not known to prcd.
+const otherLabel = "other"
+
+// observeHookReceived counts a received hook, the hooks without an agent are counted as other.
+func observeHookReceived(hookName string, agent HookAgent) {
+	if _, ok := agent.(*DefaultHookAgent); ok {
+		hookName = otherLabel
+	}
+	hooksReceived.WithLabelValues(hookName, agent.Name()).Inc()
+}
+
+func observeHookError(errorCode int) {
+	hookErrors.WithLabelValues(strconv.Itoa(errorCode)).Inc()
+}
+
+func observeProjectMatch(project, environment string, matched bool) {
+	result := "matched"
+	if !matched {
+		result = "unmatched"
+	}
+	project, environment = configuredMatchLabels(project, environment)
+	projectMatches.WithLabelValues(project, environment, result).Inc()
+}
+
+// configuredMatchLabels returns the project and environment as label values, each is other if no
+// project config entry has it.
+func configuredMatchLabels(project, environment string) (string, string) {
+	projectLabel, environmentLabel := otherLabel, otherLabel
+	for _, config := range jenkinsProjectConfigs() {
+		if config.VcsProject == project {
+			projectLabel = project
+		}
+		if config.Environment == environment {
+			environmentLabel = environment
+		}
+	}
+	return projectLabel, environmentLabel
+}
+
+// observeJenkinsNotify records a Jenkins notify round trip, statusCode is 0 if no response is received.
+func observeJenkinsNotify(statusCode int, start time.Time) {
+	jenkinsRequestDuration.Observe(time.Since(start).Seconds())
+	statusClass := "error"
+	if statusCode > 0 {
+		statusClass = strconv.Itoa(statusCode/100) + "xx"
+	}
+	jenkinsNotifies.WithLabelValues(statusClass).Inc()
+}
+
+// observeDryRunNotify records a Jenkins notify skipped by dry run.
+func observeDryRunNotify() {
+	jenkinsNotifies.WithLabelValues("dry_run").Inc()
+}
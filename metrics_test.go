@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveMetrics(t *testing.T) {
+	loadJenkinsProjectConfig("config/projects.sample.yaml")
+	matched := projectMatches.WithLabelValues("mimixiche-backend", "debug", "matched")
+	unmatched := projectMatches.WithLabelValues("mimixiche-backend", "debug", "unmatched")
+	other := projectMatches.WithLabelValues("other", "other", "unmatched")
+	beforeMatched, beforeUnmatched, beforeOther := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched), testutil.ToFloat64(other)
+	observeProjectMatch("mimixiche-backend", "debug", true)
+	observeProjectMatch("mimixiche-backend", "debug", false)
+	observeProjectMatch("mimixiche-backend", "debug", false)
+	observeProjectMatch("attacker/project-1", "env-1", false)
+	observeProjectMatch("attacker/project-2", "env-2", false)
+	if testutil.ToFloat64(matched)-beforeMatched != 1 || testutil.ToFloat64(unmatched)-beforeUnmatched != 2 {
+		t.Errorf("Project match metrics error, matched %v, unmatched %v",
+			testutil.ToFloat64(matched), testutil.ToFloat64(unmatched))
+	}
+	if testutil.ToFloat64(other)-beforeOther != 2 {
+		t.Errorf("Projects and environments not configured should be counted as other, actual %v", testutil.ToFloat64(other))
+	}
+
+	unknown := hooksReceived.WithLabelValues("other", (&DefaultHookAgent{}).Name())
+	before := testutil.ToFloat64(unknown)
+	observeHookReceived("random_hooks_1", &DefaultHookAgent{})
+	observeHookReceived("random_hooks_2", &DefaultHookAgent{})
+	if testutil.ToFloat64(unknown)-before != 2 {
+		t.Errorf("Unknown hook names should be counted as other, actual %v", testutil.ToFloat64(unknown))
+	}
+
+	created, refused := jenkinsNotifies.WithLabelValues("2xx"), jenkinsNotifies.WithLabelValues("error")
+	before2xx, beforeError := testutil.ToFloat64(created), testutil.ToFloat64(refused)
+	observeJenkinsNotify(201, time.Now())
+	observeJenkinsNotify(0, time.Now())
+	if testutil.ToFloat64(created)-before2xx != 1 || testutil.ToFloat64(refused)-beforeError != 1 {
+		t.Error("Jenkins notify metrics error")
+	}
+
+	parsing := hookErrors.WithLabelValues("1001")
+	before = testutil.ToFloat64(parsing)
+	observeHookError(ErrorInParsing)
+	if testutil.ToFloat64(parsing)-before != 1 {
+		t.Error("Hook error metrics error")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, name := range []string{"prcd_dispatch_in_flight", "prcd_duplicate_hooks_dropped_total",
+		"prcd_hook_parse_duration_seconds", "prcd_jenkins_request_duration_seconds"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("Metrics should contain %s", name)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/gogap/errors"
+)
+
+// Chat robot types supported by notification channels.
+const (
+	ChannelDingTalk = "dingtalk"
+	ChannelWeCom    = "wecom"
+	ChannelFeishu   = "feishu"
+	ChannelSlack    = "slack"
+	ChannelEmail    = "email"
+)
+
+// defaultNotificationTemplate is used by channels without their own template.
+const defaultNotificationTemplate = `[prcd] {{.JenkinsProject}} deploy {{.Status}}
+project: {{.Project}} ({{.Branch}} -> {{.Environment}})
+{{- if .Title}}
+pull request: {{.Title}}{{if .Sender}} by {{.Sender}}{{end}}{{end}}
+{{- if .Url}}
+{{.Url}}{{end}}
+{{- if .BuildUrl}}
+build: {{.BuildUrl}}{{end}}
+{{- if .Result}}
+result: {{.Result}}{{end}}
+{{- if .Error}}
+error: {{.Error}}{{end}}`
+
+// NotificationChannelConfig defines a chat robot or an email list which receives deploy messages.
+type NotificationChannelConfig struct {
+	Type    string `json:"type" yaml:"type"`
+	Webhook string `json:"webhook" yaml:"webhook"`
+	// Secret signs the webhook request, used by DingTalk and Feishu robots with signature check enabled.
+	Secret string `json:"secret" yaml:"secret"`
+
+	// The following filters match everything if empty.
+	Environments []string `json:"environments" yaml:"environments"`
+	Projects     []string `json:"projects" yaml:"projects"`
+	Events       []string `json:"events" yaml:"events"`
+
+	// Template is a text/template rendered with a DeployEvent.
+	Template string `json:"template" yaml:"template"`
+
+	// Smtp and Recipients are used by email channels.
+	Smtp       SmtpConfig `json:"smtp" yaml:"smtp"`
+	Recipients []string   `json:"recipients" yaml:"recipients"`
+}
+
+var notificationChannelGrp map[string]NotificationChannelConfig
+
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// loadNotificationConfig loads the notification channels, an empty filename configures none. An
+// unreadable or invalid file is an error and leaves no channel, so prcd does not run with
+// notifications silently off.
+func loadNotificationConfig(filename string) error {
+	notificationChannelGrp = nil
+	if filename == "" {
+		return nil
+	}
+	grp, problems, err := readNotificationConfig(filename)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid notification config %s: %s", filename, strings.Join(problems, "; "))
+	}
+	for _, config := range grp {
+		registerSecrets(config.Webhook, config.Secret, config.Smtp.Password)
+	}
+	notificationChannelGrp = grp
+	return nil
+}
+
+// readNotificationConfig reads a notification config and reports its problems without loading it,
+// it is shared by loadNotificationConfig and validate-config.
+func readNotificationConfig(filename string) (map[string]NotificationChannelConfig, []string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load notification config: %v", err)
+	}
+	var grp map[string]NotificationChannelConfig
+	if err := yaml.UnmarshalStrict(b, &grp); err != nil {
+		return nil, nil, fmt.Errorf("load notification config %s: %v", filename, err)
+	}
+	return grp, checkNotificationChannels(grp), nil
+}
+
+// checkNotificationChannels reports channels of an unknown type, unknown events, robots without
+// webhook, email lists without SMTP host, sender or recipients or with an unknown TLS mode, and
+// templates that do not parse. Email settings are otherwise only rejected when a deploy sends.
+func checkNotificationChannels(grp map[string]NotificationChannelConfig) []string {
+	names := make([]string, 0, len(grp))
+	for name := range grp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []string
+	for _, name := range names {
+		config := grp[name]
+		switch config.Type {
+		case ChannelDingTalk, ChannelWeCom, ChannelFeishu, ChannelSlack:
+			if config.Webhook == "" {
+				problems = append(problems, fmt.Sprintf("channel %s: missing webhook", name))
+			}
+		case ChannelEmail:
+			if config.Smtp.Host == "" || config.Smtp.From == "" || len(config.Recipients) == 0 {
+				problems = append(problems, fmt.Sprintf("channel %s: missing smtp host, from or recipients", name))
+			}
+			switch config.Smtp.Tls {
+			case "", SmtpTlsNone, SmtpStartTls, SmtpImplicitTls:
+			default:
+				problems = append(problems, fmt.Sprintf("channel %s: unknown smtp tls %q", name, config.Smtp.Tls))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("channel %s: unknown type %q", name, config.Type))
+			continue
+		}
+		for _, event := range config.Events {
+			switch event {
+			case DeployTriggered, DeployStarted, DeploySucceeded, DeployFailed:
+			default:
+				problems = append(problems, fmt.Sprintf("channel %s: unknown event %q", name, event))
+			}
+		}
+		if config.Template != "" {
+			if _, err := template.New(name).Parse(config.Template); err != nil {
+				problems = append(problems, fmt.Sprintf("channel %s: template: %v", name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// NotificationChannel is a configured chat robot or email list, it implements DeployReporter.
+type NotificationChannel struct {
+	Name   string
+	Config NotificationChannelConfig
+}
+
+func matchNotificationChannels(environment, project string) []*NotificationChannel {
+	var channels []*NotificationChannel
+	for name, config := range notificationChannelGrp {
+		if matchFilter(config.Environments, environment) && matchFilter(config.Projects, project) {
+			channels = append(channels, &NotificationChannel{Name: name, Config: config})
+		}
+	}
+	return channels
+}
+
+func matchFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Report implements DeployReporter. By default a channel receives triggered, succeeded and failed
+// deploys, failures are logged only.
+func (channel *NotificationChannel) Report(event DeployEvent) {
+	events := channel.Config.Events
+	if len(events) == 0 {
+		events = []string{DeployTriggered, DeploySucceeded, DeployFailed}
+	}
+	if !matchFilter(events, event.Status) {
+		return
+	}
+	if err := channel.Send(event); err != nil {
+		event.logger().Error("notification failed", "channel", channel.Name, "status", event.Status, "error", err)
+	}
+}
+
+// Send delivers the event to the channel, by email or by posting the rendered template to the
+// robot webhook.
+func (channel *NotificationChannel) Send(event DeployEvent) error {
+	if channel.Config.Type == ChannelEmail {
+		return sendDeployEmail(channel.Config.Smtp, channel.Config.Recipients, event)
+	}
+	text, err := channel.render(event)
+	if err != nil {
+		return err
+	}
+	webhook, body, err := channel.request(text, time.Now())
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notificationClient.Post(webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("webhook status " + resp.Status)
+	}
+	if channel.Config.Type == ChannelSlack {
+		return nil
+	}
+
+	// DingTalk and WeCom reply errcode, Feishu replies code (StatusCode in old versions).
+	var result struct {
+		ErrCode    int    `json:"errcode"`
+		ErrMsg     string `json:"errmsg"`
+		Code       int    `json:"code"`
+		Msg        string `json:"msg"`
+		StatusCode int    `json:"StatusCode"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 || result.Code != 0 || result.StatusCode != 0 {
+		return errors.New("webhook error " + result.ErrMsg + result.Msg)
+	}
+	event.logger().Info("notification sent", "channel", channel.Name, "status", event.Status,
+		"project", event.Project, "jenkins_project", event.JenkinsProject)
+	return nil
+}
+
+func (channel *NotificationChannel) render(event DeployEvent) (string, error) {
+	text := channel.Config.Template
+	if text == "" {
+		text = defaultNotificationTemplate
+	}
+	tpl, err := template.New(channel.Name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// request builds the webhook url and message body of the robot type, signing it if a secret is set.
+func (channel *NotificationChannel) request(text string, now time.Time) (string, interface{}, error) {
+	webhook, secret := channel.Config.Webhook, channel.Config.Secret
+	switch channel.Config.Type {
+	case ChannelDingTalk:
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+			sign := hmacSha256Base64([]byte(secret), timestamp+"\n"+secret)
+			webhook += "&timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+		}
+		return webhook, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}, nil
+	case ChannelWeCom:
+		return webhook, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}, nil
+	case ChannelFeishu:
+		body := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.Unix(), 10)
+			body["timestamp"] = timestamp
+			body["sign"] = hmacSha256Base64([]byte(timestamp+"\n"+secret), "")
+		}
+		return webhook, body, nil
+	case ChannelSlack:
+		return webhook, map[string]string{"text": text}, nil
+	}
+	return "", nil, errors.New("unknown notification channel type " + strings.TrimSpace(channel.Config.Type))
+}
+
+func hmacSha256Base64(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
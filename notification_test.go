@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationConfigParsing(t *testing.T) {
+	loadNotificationConfig("config/notifications.sample.yaml")
+	defer loadNotificationConfig("")
+	config, ok := notificationChannelGrp["backend-dingtalk"]
+	if !ok || config.Type != ChannelDingTalk || config.Secret != "SECabcdefg1234" ||
+		len(config.Environments) != 1 || config.Environments[0] != "production" {
+		t.Errorf("Notification config backend-dingtalk parse failed, actual %+v", config)
+	}
+
+	names := map[string]bool{}
+	for _, channel := range matchNotificationChannels("production", "mimixiche-backend") {
+		names[channel.Name] = true
+	}
+	if len(names) != 5 {
+		t.Errorf("All channels should match production backend, actual %v", names)
+	}
+	names = map[string]bool{}
+	for _, channel := range matchNotificationChannels("debug", "mingdao") {
+		names[channel.Name] = true
+	}
+	if len(names) != 1 || !names["ops-slack"] {
+		t.Errorf("Only ops-slack should match debug mingdao, actual %v", names)
+	}
+}
+
+func TestNotificationChannel_Render(t *testing.T) {
+	channel := NotificationChannel{Name: "test"}
+	event := DeployEvent{
+		Status:         DeploySucceeded,
+		Project:        "mingdao",
+		Branch:         "master",
+		Environment:    "production",
+		Title:          "Fix paging",
+		Sender:         "akimimi",
+		JenkinsProject: "production-mingdao",
+		BuildUrl:       "http://jenkins/job/production-mingdao/3/",
+		Result:         "SUCCESS",
+	}
+	text, err := channel.render(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"production-mingdao deploy succeeded", "Fix paging by akimimi",
+		"build: http://jenkins/job/production-mingdao/3/", "result: SUCCESS"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Rendered message should contain %q, actual %s", expected, text)
+		}
+	}
+
+	channel.Config.Template = "{{.Project}} {{.Result}}"
+	if text, _ := channel.render(event); text != "mingdao SUCCESS" {
+		t.Errorf("Custom template error, actual %s", text)
+	}
+	channel.Config.Template = "{{.Project"
+	if _, err := channel.render(event); err == nil {
+		t.Error("Invalid template should fail.")
+	}
+}
+
+func TestNotificationChannel_Sign(t *testing.T) {
+	now := time.Unix(1627033709, 254000000)
+	channel := NotificationChannel{Config: NotificationChannelConfig{
+		Type:    ChannelDingTalk,
+		Webhook: "https://oapi.dingtalk.com/robot/send?access_token=abc",
+		Secret:  "SEC000",
+	}}
+	webhook, _, err := channel.request("hi", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(webhook)
+	sign := hmacSha256Base64([]byte("SEC000"), "1627033709254\nSEC000")
+	if u.Query().Get("timestamp") != "1627033709254" || u.Query().Get("sign") != sign {
+		t.Errorf("DingTalk sign error, actual %s", webhook)
+	}
+
+	channel.Config = NotificationChannelConfig{Type: ChannelFeishu, Webhook: "https://open.feishu.cn/hook", Secret: "abc"}
+	_, body, _ := channel.request("hi", now)
+	feishu := body.(map[string]interface{})
+	if feishu["timestamp"] != "1627033709" || feishu["sign"] != hmacSha256Base64([]byte("1627033709\nabc"), "") {
+		t.Errorf("Feishu sign error, actual %v", feishu)
+	}
+
+	channel.Config = NotificationChannelConfig{Type: "irc"}
+	if _, _, err := channel.request("hi", now); err == nil {
+		t.Error("Unknown channel type should fail.")
+	}
+}
+
+func TestNotificationChannel_Report(t *testing.T) {
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			Text struct {
+				Content string `json:"content"`
+			} `json:"text"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body.Text.Content)
+		if strings.Contains(body.Text.Content, "reject") {
+			w.Write([]byte(`{"errcode":310000,"errmsg":"sign not match"}`))
+			return
+		}
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer ts.Close()
+
+	channel := NotificationChannel{Name: "wecom", Config: NotificationChannelConfig{
+		Type:     ChannelWeCom,
+		Webhook:  ts.URL,
+		Template: "{{.Project}} {{.Status}}",
+	}}
+	for _, status := range []string{DeployTriggered, DeployStarted, DeploySucceeded, DeployFailed} {
+		channel.Report(DeployEvent{Status: status, Project: "mingdao"})
+	}
+	if len(received) != 3 || received[1] != "mingdao succeeded" {
+		t.Errorf("Channel should receive triggered, succeeded and failed events, actual %v", received)
+	}
+	if err := channel.Send(DeployEvent{Project: "reject"}); err == nil {
+		t.Error("Send should fail on robot errcode.")
+	}
+}
+
+func TestLoadNotificationConfig_Invalid(t *testing.T) {
+	defer loadNotificationConfig("")
+	filename := filepath.Join(t.TempDir(), "notifications.yaml")
+	for config, problem := range map[string]string{
+		"ops:\n  type: wechat\n  webhook: http://robot\n":                                       `channel ops: unknown type "wechat"`,
+		"ops:\n  type: slack\n":                                                                 "channel ops: missing webhook",
+		"ops:\n  type: email\n  smtp:\n    host: smtp.local\n":                                  "channel ops: missing smtp host, from or recipients",
+		"ops:\n  type: email\n  smtp:\n    host: smtp.local\n  recipients: [ops@example.com]\n": "channel ops: missing smtp host, from or recipients",
+		"ops:\n  type: email\n  smtp:\n    host: smtp.local\n    from: prcd@example.com\n    tls: ssl3\n  recipients: [ops@example.com]\n": `channel ops: unknown smtp tls "ssl3"`,
+		"ops:\n  type: slack\n  webhook: http://robot\n  events: [succeded]\n":                                                             `channel ops: unknown event "succeded"`,
+		"ops:\n  type: slack\n  webhok: http://robot\n":                                                                                    "field webhok not found",
+		"ops:\n  type: slack\n  webhook: http://robot\n  template: \"{{.Status\"\n":                                                        "channel ops: template:",
+	} {
+		ioutil.WriteFile(filename, []byte(config), 0640)
+		if err := loadNotificationConfig(filename); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Config %q should fail with %q, actual %v", config, problem, err)
+		}
+		if len(notificationChannelGrp) != 0 {
+			t.Errorf("An invalid config should leave no channel, actual %v", notificationChannelGrp)
+		}
+	}
+	if err := loadNotificationConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("A missing notification config should fail")
+	}
+	if err := loadNotificationConfig("config/notifications.sample.yaml"); err != nil {
+		t.Errorf("The sample notification config should load, actual %v", err)
+	}
+
+	ioutil.WriteFile(filename, []byte("ops:\n  type: slack\n  webhook: http://robot\n  events: [succeded]\n"), 0640)
+	if code := runValidateConfig([]string{"-notification-config-file", filename, "config/projects.sample.yaml"}); code != 1 {
+		t.Errorf("validate-config should fail on an invalid notification config, actual exit code %d", code)
+	}
+	if code := runValidateConfig([]string{"-notification-config-file", "config/notifications.sample.yaml", "config/projects.sample.yaml"}); code != 0 {
+		t.Errorf("validate-config should accept the sample notification config, actual exit code %d", code)
+	}
+}
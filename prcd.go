@@ -1,37 +1,137 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gogap/logs"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Error codes for where the working flow stops.
 const (
-	ErrorInParsing = 1001
-	ErrorInGetData = 1002
+	ErrorInParsing   = 1001
+	ErrorInGetData   = 1002
+	ErrorInAgent     = 1003
+	ErrorInSignature = 1004
 )
 
+// subcommands are run by "prcd <name> [args]" instead of the server, the function returns the
+// exit code.
+var subcommands = map[string]func(args []string) int{
+	"verify-audit":    runVerifyAudit,
+	"explain":         runExplain,
+	"validate-config": runValidateConfig,
+	"simulate":        runSimulate,
+	"replay":          runReplay,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
 	loadParameters()
-	loadJenkinsProjectConfig(settings.jenkinsProjectConfigFile)
+	shutdownTracing, err := setupTracing(settings.otlpEndpoint, settings.otlpInsecure)
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing()
+	reopenLogFilesOnSignal()
+	if settings.auditLogFile != "" {
+		if auditLog, err = openAuditLog(settings.auditLogFile, strings.Split(settings.auditEnvironments, ",")); err != nil {
+			panic(err)
+		}
+		defer auditLog.Close()
+	}
+	if settings.historyDbFile != "" {
+		if historyStore, err = openHistoryStore(settings.historyDbFile); err != nil {
+			panic(err)
+		}
+		defer historyStore.Close()
+		go pruneHistory(historyStore, time.Hour)
+	}
+	if err := loadJenkinsProjectConfig(settings.jenkinsProjectConfigFile); err != nil {
+		logger.Error("load jenkins project config failed", "error", err)
+		panic(err)
+	}
+	reloadProjectConfigOnSignal(settings.jenkinsProjectConfigFile)
+	if settings.projectConfigWatch > 0 {
+		go watchJenkinsProjectConfig(settings.jenkinsProjectConfigFile, settings.projectConfigWatch, nil)
+	}
+	if err := loadNotificationConfig(settings.notificationConfigFile); err != nil {
+		logger.Error("load notification config failed", "error", err)
+		panic(err)
+	}
+	if err := loadCommandConfig(settings.commandConfigFile); err != nil {
+		logger.Error("load command config failed", "error", err)
+		panic(err)
+	}
 	r := createGinEngine()
 	r.POST(settings.notifyUrl, onNotify)
-	if e := r.Run(fmt.Sprintf("%s:%d", settings.hookListeningIp, settings.hookListeningPort)); e == nil {
-		logs.Info("Listening on ", settings.hookListeningIp, ":", settings.hookListeningPort)
-	} else {
-		logs.Error(e)
+	r.GET(settings.metricsUrl, gin.WrapH(promhttp.Handler()))
+	r.GET(settings.healthzUrl, onHealthz)
+	r.GET(settings.readyzUrl, onReadyz)
+	if settings.adminToken != "" {
+		r.POST(settings.adminReloadUrl, onReloadProjects)
+		if settings.explainUrl != "" {
+			r.POST(settings.explainUrl, onExplain)
+		}
+	}
+	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", settings.hookListeningIp, settings.hookListeningPort), Handler: r}
+	if dashboardSrv := createDashboardServer(); dashboardSrv != nil {
+		// Dashboard streams never end on their own, they are closed with the hook server.
+		srv.RegisterOnShutdown(func() { dashboardSrv.Close() })
+		go func() {
+			logger.Info("dashboard listening", "address", dashboardSrv.Addr)
+			if e := dashboardSrv.ListenAndServe(); e != http.ErrServerClosed {
+				logger.Error("dashboard stopped", "error", e)
+				panic(e)
+			}
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(srv)
+		close(stopped)
+	}()
+	logger.Info("listening", "host", settings.hookListeningIp, "port", settings.hookListeningPort)
+	if e := srv.ListenAndServe(); e != http.ErrServerClosed {
+		logger.Error("server stopped", "error", e)
 		panic(e)
 	}
+	<-stopped
+}
+
+// shutdownOnSignal stops the server on SIGINT or SIGTERM. It stops receiving hooks, then waits
+// for the running hook dispatches, at most settings.shutdownTimeout for both.
+func shutdownOnSignal(srv *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("shutting down", "signal", sig.String())
+	ctx, cancel := context.WithTimeout(context.Background(), settings.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("stop receiving hooks failed", "error", err)
+	}
+	drainDispatches(ctx)
 }
 
 var settings struct {
@@ -47,6 +147,46 @@ var settings struct {
 	notifyUrl                string
 	verbose                  bool
 	dedupWindowSeconds       int64
+	githubApiUrl             string
+	githubToken              string
+	jenkinsPollInterval      int64
+	jenkinsBuildTimeout      int64
+	notificationConfigFile   string
+	giteeApiUrl              string
+	giteeToken               string
+	commandConfigFile        string
+	metricsUrl               string
+	logFormat                string
+	otlpEndpoint             string
+	otlpInsecure             bool
+	healthzUrl               string
+	readyzUrl                string
+	readyMaxPending          int64
+	readyCheckJenkins        bool
+	historyDbFile            string
+	historyRetentionDays     int64
+	historyMaxRecords        int64
+	dashboardUrl             string
+	dashboardListen          string
+	logMaxSizeMb             int64
+	logRotateInterval        time.Duration
+	logCompress              bool
+	logMaxBackups            int64
+	logMaxAgeDays            int64
+	auditLogFile             string
+	auditEnvironments        string
+	explainUrl               string
+	dryRun                   bool
+	configFile               string
+	printConfig              bool
+	projectConfigWatch       time.Duration
+	adminToken               string
+	webhookSecret            string
+	adminReloadUrl           string
+	vaultAddr                string
+	vaultToken               string
+	hookArchiveDir           string
+	shutdownTimeout          time.Duration
 }
 
 var (
@@ -80,28 +220,101 @@ func isDuplicateMessage(b []byte) bool {
 	return false
 }
 
+// registerJenkinsFlags defines the flags of the default Jenkins server profile, shared by the server
+// and the subcommands reading the project config.
+func registerJenkinsFlags(flags *flag.FlagSet) {
+	flags.StringVar(&settings.jenkinsHost, "jenkins-host", "http://cd.mimixiche.cn", "Jenkins host address.")
+	flags.StringVar(&settings.jenkinsNotifyUrl, "jenkins-url", "/job/<project>/build?token=<token>", "Jenkins notify URL.")
+	flags.StringVar(&settings.jenkinsUserName, "jenkins-user-name", "", "Jenkins User Name.")
+	flags.StringVar(&settings.jenkinsUserApiToken, "jenkins-api-token", "", "Jenkins User API Token, or a secret reference such as ${env:NAME}, ${file:/run/secrets/name} or ${vault:secret/data/prcd#key}.")
+}
+
+// registerSettingFlags defines a flag for every server setting.
+func registerSettingFlags(flags *flag.FlagSet) {
+	flags.StringVar(&settings.hookRequestLogFile, "hook-log-file", "hook-request.log", "Hook request log file")
+	flags.StringVar(&settings.hookMessageLogFile, "message-log-file", "message.log", "Hook message log")
+	flags.StringVar(&settings.hookListeningIp, "h", "", "Server listening host address(IP or hostname).")
+	flags.StringVar(&settings.hookListeningIp, "host", "", "Server listening host address(IP or hostname).")
+	flags.Int64Var(&settings.hookListeningPort, "p", 8889, "Server listening port.")
+	flags.Int64Var(&settings.hookListeningPort, "port", 8889, "Server listening port.")
+	flags.BoolVar(&settings.verbose, "verbose", false, "Print debug logs if verbose is set")
+	registerJenkinsFlags(flags)
+	flags.StringVar(&settings.jenkinsProjectConfigFile, "jenkins-project-config-file", "/etc/prcd/projects.yaml", "Jenkins Project config file.")
+	flags.StringVar(&settings.notifyUrl, "notify-url", "/notify", "Listening url address.")
+	flags.Int64Var(&settings.dedupWindowSeconds, "dedup-window-seconds", 10, "Drop identical webhook payloads received within this many seconds (0 disables).")
+	flags.StringVar(&settings.githubApiUrl, "github-api-url", "https://api.github.com", "GitHub API address for deployment feedback.")
+	flags.StringVar(&settings.githubToken, "github-token", "", "GitHub token for deployment feedback, GitHub deployments are disabled if empty.")
+	flags.Int64Var(&settings.jenkinsPollInterval, "jenkins-poll-interval-seconds", 5, "Interval of polling a triggered Jenkins build.")
+	flags.Int64Var(&settings.jenkinsBuildTimeout, "jenkins-build-timeout-seconds", 1800, "Give up following a triggered Jenkins build after this many seconds.")
+	flags.StringVar(&settings.notificationConfigFile, "notification-config-file", "", "Chat notification channels config file, notifications are disabled if empty.")
+	flags.StringVar(&settings.giteeApiUrl, "gitee-api-url", "https://gitee.com/api/v5", "Gitee API address for comment replies.")
+	flags.StringVar(&settings.giteeToken, "gitee-token", "", "Gitee access token for comment replies.")
+	flags.StringVar(&settings.commandConfigFile, "command-config-file", "", "Comment command allowlist config file, comment commands are rejected if empty or without -webhook-secret.")
+	flags.StringVar(&settings.metricsUrl, "metrics-url", "/metrics", "Prometheus metrics url address.")
+	flags.StringVar(&settings.logFormat, "log-format", LogFormatText, "Message log format, text or json.")
+	flags.StringVar(&settings.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector address (host:port) to export traces to, tracing is disabled if empty.")
+	flags.BoolVar(&settings.otlpInsecure, "otlp-insecure", false, "Export traces over plain HTTP instead of HTTPS.")
+	flags.StringVar(&settings.healthzUrl, "healthz-url", "/healthz", "Liveness probe url address.")
+	flags.StringVar(&settings.readyzUrl, "readyz-url", "/readyz", "Readiness probe url address.")
+	flags.Int64Var(&settings.readyMaxPending, "readyz-max-pending", 100, "Report not ready when this many hook dispatches are pending (0 disables).")
+	flags.BoolVar(&settings.readyCheckJenkins, "readyz-check-jenkins", false, "Report not ready when the default Jenkins host is unreachable.")
+	flags.StringVar(&settings.historyDbFile, "history-db-file", "history.db", "Deployment history database file, the history is disabled if empty. It is served on /api/deployments of -dashboard-listen only.")
+	flags.Int64Var(&settings.historyRetentionDays, "history-retention-days", 90, "Delete deployment history older than this many days (0 keeps all).")
+	flags.Int64Var(&settings.historyMaxRecords, "history-max-records", 0, "Keep at most this many deployment history records (0 keeps all).")
+	flags.StringVar(&settings.dashboardUrl, "dashboard-url", "/dashboard", "Web dashboard url address.")
+	flags.StringVar(&settings.dashboardListen, "dashboard-listen", "", "Listen address of the web dashboard and the deployment APIs (/api/deployments...), e.g. 127.0.0.1:8890, they are disabled if empty, the main listen address does not serve them. They have no authentication, keep the address private.")
+	flags.Int64Var(&settings.logMaxSizeMb, "log-max-size-mb", 100, "Rotate the request and message logs when they exceed this many megabytes (0 disables).")
+	flags.DurationVar(&settings.logRotateInterval, "log-rotate-interval", 0, "Rotate the request and message logs at this interval, e.g. 24h (0 disables).")
+	flags.BoolVar(&settings.logCompress, "log-compress", false, "Gzip rotated logs.")
+	flags.Int64Var(&settings.logMaxBackups, "log-max-backups", 10, "Keep this many rotated files of each log (0 keeps all).")
+	flags.Int64Var(&settings.logMaxAgeDays, "log-max-age-days", 0, "Remove rotated logs older than this many days (0 keeps all).")
+	flags.StringVar(&settings.auditLogFile, "audit-log-file", "audit.log", "Hash-chained audit log of deploy triggers and comment commands, auditing is disabled if empty.")
+	flags.StringVar(&settings.auditEnvironments, "audit-environments", "production", "Comma separated environments to audit, all environments are audited if empty.")
+	flags.StringVar(&settings.explainUrl, "explain-url", "", "Admin url address explaining how a posted hook payload is matched, e.g. /debug/explain, disabled if empty.")
+	flags.BoolVar(&settings.dryRun, "dry-run", false, "Run hooks up to the Jenkins notify without notifying Jenkins or replying to comments.")
+	flags.DurationVar(&settings.projectConfigWatch, "jenkins-project-config-watch-interval", 5*time.Second, "Reload the Jenkins Project config file when it changes, checking at this interval (0 disables).")
+	flags.StringVar(&settings.webhookSecret, "webhook-secret", "", "Secret of the webhooks: hooks without a valid GitHub signature or GitLab/Gitee token are refused, and replays are signed with it. Hooks are not checked if empty.")
+	flags.StringVar(&settings.adminToken, "admin-token", "", "Bearer token of the admin endpoints, they are disabled if empty.")
+	flags.StringVar(&settings.adminReloadUrl, "admin-reload-url", "/admin/reload-projects", "Admin url address reloading the Jenkins Project config file.")
+	flags.StringVar(&settings.vaultAddr, "vault-addr", "", "HashiCorp Vault address resolving vault: secret references, VAULT_ADDR is used if empty.")
+	flags.StringVar(&settings.vaultToken, "vault-token", "", "HashiCorp Vault token resolving vault: secret references, VAULT_TOKEN is used if empty.")
+	flags.StringVar(&settings.hookArchiveDir, "hook-archive-dir", "", "Directory keeping every received hook payload for replay, the archive is disabled if empty.")
+	flags.DurationVar(&settings.shutdownTimeout, "shutdown-timeout", 30*time.Second, "On SIGTERM or SIGINT, wait this long for the hooks being received and the running hook dispatches.")
+	flags.StringVar(&settings.configFile, "config", "", "YAML server config file, its keys are the flag names. Flags take precedence over PRCD_* environment variables, which take precedence over the file.")
+	flags.BoolVar(&settings.printConfig, "print-config", false, "Print the effective settings with secrets masked and exit.")
+}
+
 func loadParameters() {
-	flag.StringVar(&settings.hookRequestLogFile, "hook-log-file", "hook-request.log", "Hook request log file")
-	flag.StringVar(&settings.hookMessageLogFile, "message-log-file", "message.log", "Hook message log")
-	flag.StringVar(&settings.hookListeningIp, "h", "", "Server listening host address(IP or hostname).")
-	flag.StringVar(&settings.hookListeningIp, "host", "", "Server listening host address(IP or hostname).")
-	flag.Int64Var(&settings.hookListeningPort, "p", 8889, "Server listening port.")
-	flag.Int64Var(&settings.hookListeningPort, "port", 8889, "Server listening port.")
-	flag.BoolVar(&settings.verbose, "verbose", false, "Print debug logs if verbose is set")
-	flag.StringVar(&settings.jenkinsHost, "jenkins-host", "http://cd.mimixiche.cn", "Jenkins host address.")
-	flag.StringVar(&settings.jenkinsNotifyUrl, "jenkins-url", "/job/<project>/build?token=<token>", "Jenkins notify URL.")
-	flag.StringVar(&settings.jenkinsUserName, "jenkins-user-name", "", "Jenkins User Name.")
-	flag.StringVar(&settings.jenkinsUserApiToken, "jenkins-api-token", "", "Jenkins User API Token.")
-	flag.StringVar(&settings.jenkinsProjectConfigFile, "jenkins-project-config-file", "/etc/prcd/projects.yaml", "Jenkins Project config file.")
-	flag.StringVar(&settings.notifyUrl, "notify-url", "/notify", "Listening url address.")
-	flag.Int64Var(&settings.dedupWindowSeconds, "dedup-window-seconds", 10, "Drop identical webhook payloads received within this many seconds (0 disables).")
+	registerSettingFlags(flag.CommandLine)
 	flag.Parse()
-	logs.SetFileLogger(settings.hookMessageLogFile)
-	if !settings.verbose {
-		logs.SetLoggerLevel(logs.LevelInfo)
+	sources, err := applySettingSources(flag.CommandLine, os.LookupEnv)
+	if err != nil {
+		panic(err)
+	}
+	if settings.printConfig {
+		printSettings(os.Stdout, flag.CommandLine, sources)
+		os.Exit(0)
 	}
-	f, _ := os.Create(settings.hookRequestLogFile)
-	gin.DefaultWriter = io.MultiWriter(f)
+	if err := resolveSecretSettings(flag.CommandLine); err != nil {
+		panic(err)
+	}
+	registerSecrets(settings.jenkinsUserApiToken, settings.githubToken, settings.giteeToken, settings.adminToken,
+		settings.webhookSecret)
+	rotation := LogRotation{
+		MaxSize:    settings.logMaxSizeMb * 1024 * 1024,
+		Interval:   settings.logRotateInterval,
+		Compress:   settings.logCompress,
+		MaxBackups: int(settings.logMaxBackups),
+		MaxAge:     time.Duration(settings.logMaxAgeDays) * 24 * time.Hour,
+	}
+	if err := setupMessageLog(settings.hookMessageLogFile, settings.logFormat, settings.verbose, rotation); err != nil {
+		panic(err)
+	}
+	f, err := openRotatingFile(settings.hookRequestLogFile, rotation)
+	if err != nil {
+		panic(err)
+	}
+	gin.DefaultWriter = f
 }
 
 func createGinEngine() *gin.Engine {
@@ -111,12 +324,12 @@ func createGinEngine() *gin.Engine {
 			param.ClientIP,
 			param.TimeStamp.Format(time.RFC1123),
 			param.Method,
-			param.Path,
+			redactString(param.Path),
 			param.Request.Proto,
 			param.StatusCode,
 			param.Latency,
 			param.Request.UserAgent(),
-			param.ErrorMessage,
+			redactString(param.ErrorMessage),
 		)
 	}))
 	r.Use(gin.Recovery())
@@ -125,20 +338,56 @@ func createGinEngine() *gin.Engine {
 
 func onNotify(c *gin.Context) {
 	errorCode, errorMessage := 0, "ok"
+	correlationId := newCorrelationId(c.GetHeader)
+	log := Logger{CorrelationId: correlationId}
+	c.Header("X-Correlation-Id", correlationId)
+	ctx, span := tracer.Start(c.Request.Context(), "receive hook", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("prcd.correlation_id", correlationId)))
 	var e error
+	defer func() { endSpan(span, e) }()
 	if b, err := c.GetRawData(); err == nil {
-		logs.Debug("receive post:", string(b))
-		if isDuplicateMessage(b) {
+		log.Debug("receive post", "payload", redactPayload(b))
+		if !verifyHookSignature(c.GetHeader, b) {
+			e = errors.New("invalid webhook signature")
+			log.Error("receive hook failed", "errcode", ErrorInSignature, "error", e)
+			observeHookError(ErrorInSignature)
+			c.JSON(http.StatusUnauthorized, gin.H{"errcode": ErrorInSignature, "errmsg": e.Error(), "correlation_id": correlationId})
+			return
+		}
+		duplicate := false
+		// Only a signed replay skips the dedup check, without a webhook secret anyone could send the header.
+		if replayOf := c.GetHeader(replayHeader); replayOf != "" && settings.webhookSecret != "" {
+			log.Info("replayed delivery, dedup check skipped", "replay_of", replayOf)
+		} else {
+			_, dedupSpan := tracer.Start(ctx, "dedup check")
+			duplicate = isDuplicateMessage(b)
+			dedupSpan.SetAttributes(attribute.Bool("prcd.duplicate", duplicate))
+			dedupSpan.End()
+		}
+		if duplicate {
 			// 提到 Info 级，让默认日志也能看到去重命中。
-			logs.Info("duplicate webhook payload dropped (within ",
-				settings.dedupWindowSeconds, " seconds)")
-			c.JSON(200, gin.H{"errcode": 0, "errmsg": "duplicate dropped"})
+			log.Info("duplicate webhook payload dropped",
+				"dedup_window_seconds", settings.dedupWindowSeconds)
+			duplicatesDropped.Inc()
+			c.JSON(200, gin.H{"errcode": 0, "errmsg": "duplicate dropped", "correlation_id": correlationId})
 			return
 		}
 		basicHook := BasicHook{}
 		if err := json.Unmarshal(b, &basicHook); err == nil {
-			logs.Info("received hook hook_name=", basicHook.HookName, " hook_id=", basicHook.HookId)
-			go sendNotice(basicHook, b)
+			if event := c.GetHeader("X-GitHub-Event"); basicHook.HookName == "" && event != "" {
+				basicHook.HookName = githubHookName(event)
+			}
+			log.Info("received hook", "hook_name", basicHook.HookName, "hook_id", basicHook.HookId)
+			archiveHook(log, HookDelivery{CorrelationId: correlationId, HookName: basicHook.HookName,
+				ReceivedAt: time.Now(), Payload: b})
+			span.SetAttributes(attribute.String("prcd.hook_name", basicHook.HookName),
+				attribute.Int("prcd.hook_id", basicHook.HookId))
+			// The dispatch outlives the request, keep its trace but not its cancellation.
+			hookDispatches.Add(1)
+			go func() {
+				defer hookDispatches.Done()
+				sendNotice(trace.ContextWithSpan(context.Background(), span), correlationId, basicHook, b)
+			}()
 		} else {
 			e, errorCode = err, ErrorInParsing
 		}
@@ -148,36 +397,89 @@ func onNotify(c *gin.Context) {
 
 	if e != nil {
 		errorMessage = e.Error()
-		logs.Error(e)
+		log.Error("receive hook failed", "errcode", errorCode, "error", e)
+		observeHookError(errorCode)
+	}
+	response := gin.H{"errcode": errorCode, "errmsg": errorMessage, "correlation_id": correlationId}
+	if settings.dryRun {
+		response["dry_run"] = true
 	}
-	c.JSON(200, gin.H{"errcode": errorCode, "errmsg": errorMessage})
+	c.JSON(200, response)
 }
 
-func sendNotice(basicHook BasicHook, bytes []byte) {
+func sendNotice(ctx context.Context, correlationId string, basicHook BasicHook, bytes []byte) {
+	dispatchInFlight.Inc()
+	defer dispatchInFlight.Dec()
+	atomic.AddInt64(&pendingDispatches, 1)
+	defer atomic.AddInt64(&pendingDispatches, -1)
+	log := Logger{CorrelationId: correlationId}
 	agent := createHookAgentByName(basicHook.HookName)
-	logs.Debug("match agent:", agent.Name())
-	if e := agent.Parse(bytes); e == nil {
+	log.Debug("match agent", "agent", agent.Name())
+	observeHookReceived(basicHook.HookName, agent)
+	record := &DeploymentRecord{CorrelationId: correlationId, HookName: basicHook.HookName, HookId: basicHook.HookId,
+		Agent: agent.Name(), Status: HistoryReceived, ReceivedAt: time.Now()}
+	hookAttrs := []attribute.KeyValue{
+		attribute.String("prcd.hook_name", basicHook.HookName),
+		attribute.Int("prcd.hook_id", basicHook.HookId),
+		attribute.String("prcd.correlation_id", correlationId),
+	}
+	_, parseSpan := tracer.Start(ctx, "agent parse", trace.WithAttributes(hookAttrs...))
+	start := time.Now()
+	e := agent.Parse(bytes)
+	hookParseDuration.Observe(time.Since(start).Seconds())
+	if e == nil {
+		hookAttrs = append(hookAttrs, hookAttributes(agent)...)
+		parseSpan.SetAttributes(hookAttributes(agent)...)
+	}
+	endSpan(parseSpan, e)
+	if e == nil {
+		record.setHook(agent)
 		project, branch, env := agent.HookProject(), agent.HookBranch(), agent.Environment()
 		canTrigger := agent.CanTriggerEvent()
-		logs.Info("hook parsed agent=", agent.Name(),
-			" project=", project, " branch=", branch, " environment=", env,
-			" can_trigger=", canTrigger)
+		log.Info("hook parsed", "agent", agent.Name(),
+			"project", project, "branch", branch, "environment", env,
+			"can_trigger", canTrigger)
+		if commentAgent, ok := agent.(CommentAgent); ok {
+			saveDeploymentRecord(log, record)
+			handleCommentCommand(ctx, log, basicHook, commentAgent)
+			return
+		}
 		if canTrigger {
+			_, matchSpan := tracer.Start(ctx, "match project", trace.WithAttributes(hookAttrs...))
 			notifier := createNotifierByAgent(agent)
+			notifier.CorrelationId, notifier.ctx = correlationId, ctx
+			notifier.HookName, notifier.HookId = basicHook.HookName, basicHook.HookId
+			matchSpan.SetAttributes(attribute.String("prcd.jenkins_project", notifier.JenkinsProject.Name))
+			matchSpan.End()
 			if notifier.JenkinsProject.Name == "" || notifier.JenkinsProject.Token == "" {
-				logs.Info("no jenkins project matched for environment=", env,
-					" project=", project, " branch=", branch, ", skip notify")
+				log.Info("no jenkins project matched, skip notify", "environment", env,
+					"project", project, "branch", branch)
+				observeProjectMatch(project, env, false)
+				record.Status, record.MatchReason = HistoryUnmatched, unmatchedReason(env, project, branch)
+				saveDeploymentRecord(log, record)
+				auditMatch(log, record, notifier.JenkinsProject)
 				return
 			}
-			logs.Info("matched jenkins project=", notifier.JenkinsProject.Name,
-				" host=", notifier.JenkinsProject.Host)
-			if err := notifier.Notify(); err != nil {
-				logs.Error(err)
-			}
+			observeProjectMatch(project, env, true)
+			log.Info("matched jenkins project", "jenkins_project", notifier.JenkinsProject.Name,
+				"jenkins_server", notifier.JenkinsProject.Server, "jenkins_host", notifier.server().Host,
+				"source", notifier.JenkinsProject.Source)
+			log.Debug("resolved jenkins settings", "jenkins_project", notifier.JenkinsProject.Name,
+				"settings", formatJenkinsSettings(notifier.jenkinsSettings()))
+			record.Status, record.JenkinsProjects, record.DryRun = HistoryMatched, []string{notifier.JenkinsProject.Name}, notifier.DryRun
+			saveDeploymentRecord(log, record)
+			auditMatch(log, record, notifier.JenkinsProject)
+			dispatchDeploy(agent, notifier)
 		} else {
-			logs.Debug("Agent cannot trigger event:", agent.Name())
+			log.Debug("agent cannot trigger event", "agent", agent.Name())
+			record.Status, record.MatchReason = HistoryIgnored, "agent cannot trigger a deploy from this hook"
+			saveDeploymentRecord(log, record)
+			auditMatch(log, record, JenkinsProject{})
 		}
 	} else {
-		logs.Error(e)
+		log.Error("parse hook failed", "agent", agent.Name(), "error", e)
+		observeHookError(ErrorInAgent)
+		record.Status, record.Error = HistoryParseFailed, e.Error()
+		saveDeploymentRecord(log, record)
 	}
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -65,3 +68,27 @@ func TestIsDuplicateMessage_ExpiresAfterWindow(t *testing.T) {
 		t.Fatalf("payload after window expiry should not be duplicate")
 	}
 }
+
+func TestOnNotify_CorrelationId(t *testing.T) {
+	resetDedupCache()
+	settings.dedupWindowSeconds = 10
+	r := createGinEngine()
+	r.POST("/notify", onNotify)
+
+	req := httptest.NewRequest("POST", "/notify", strings.NewReader(`{"hook_name":"unknown_hooks"}`))
+	req.Header.Set("X-Request-Id", "delivery-1")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	body := map[string]interface{}{}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if body["correlation_id"] != "delivery-1" || resp.Header().Get("X-Correlation-Id") != "delivery-1" {
+		t.Errorf("Response should carry the correlation id, actual %v", body)
+	}
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest("POST", "/notify", strings.NewReader(`not json`)))
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if body["errcode"] != float64(ErrorInParsing) || body["correlation_id"] == "" || body["correlation_id"] == "delivery-1" {
+		t.Errorf("Parse error response should carry a generated correlation id, actual %v", body)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces secrets in logs and errors.
+const redactedValue = "******"
+
+// secretFields are payload fields and query parameters whose values are secrets, compared case
+// insensitively.
+var secretFields = map[string]bool{
+	"password":               true,
+	"token":                  true,
+	"secret":                 true,
+	"access_token":           true,
+	"private_token":          true,
+	"api_token":              true,
+	"jenkins_token":          true,
+	"jenkins_user_api_token": true,
+	"sign":                   true,
+}
+
+var (
+	secretQueryPattern    = regexp.MustCompile(`(?i)([?&;](?:password|token|secret|access_token|private_token|api_token|sign)=)[^&#\s"']*`)
+	secretJsonPattern     = regexp.MustCompile(`(?i)("(?:password|token|secret|access_token|private_token|api_token|jenkins_token|jenkins_user_api_token)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	urlCredentialsPattern = regexp.MustCompile(`(://)[^/\s:@"']+:[^/\s@"']*@`)
+	authorizationPattern  = regexp.MustCompile(`(?i)(authorization:\s*(?:basic|bearer|token)\s+)\S+`)
+)
+
+var (
+	knownSecrets   = make(map[string]bool)
+	knownSecretsMu sync.RWMutex
+)
+
+// registerSecrets adds configured secrets, such as tokens, to be masked wherever they show up.
+// Values shorter than 4 characters are ignored to keep logs readable.
+func registerSecrets(values ...string) {
+	knownSecretsMu.Lock()
+	defer knownSecretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 {
+			knownSecrets[v] = true
+		}
+	}
+}
+
+// redactString masks registered secrets, secret query values, URL credentials, authorization
+// header values and secret JSON fields in s.
+func redactString(s string) string {
+	knownSecretsMu.RLock()
+	for secret := range knownSecrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	knownSecretsMu.RUnlock()
+	s = secretQueryPattern.ReplaceAllString(s, "${1}"+redactedValue)
+	s = secretJsonPattern.ReplaceAllString(s, `${1}"`+redactedValue+`"`)
+	s = urlCredentialsPattern.ReplaceAllString(s, "${1}"+redactedValue+"@")
+	s = authorizationPattern.ReplaceAllString(s, "${1}"+redactedValue)
+	return s
+}
+
+// redactPayload returns a hook payload with the values of secret fields masked. The payload is
+// masked in place, key order, numbers and spacing are kept as received.
+func redactPayload(b []byte) string {
+	spans, err := secretValueSpans(b)
+	if err != nil {
+		return redactString(string(b))
+	}
+	redacted := make([]byte, 0, len(b))
+	offset := int64(0)
+	for _, span := range spans {
+		redacted = append(redacted, b[offset:span[0]]...)
+		redacted = append(redacted, '"')
+		redacted = append(redacted, redactedValue...)
+		redacted = append(redacted, '"')
+		offset = span[1]
+	}
+	redacted = append(redacted, b[offset:]...)
+	return redactString(string(redacted))
+}
+
+// secretValueSpans returns the byte ranges of the non-empty values of secret fields in a JSON
+// payload, in order.
+func secretValueSpans(b []byte) ([][2]int64, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// objects holds whether each open container is an object, expectKey whether the next token
+	// of the innermost object is a key.
+	var objects []bool
+	expectKey := false
+	var spans [][2]int64
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		inObject := len(objects) > 0 && objects[len(objects)-1]
+		if key, ok := tok.(string); ok && inObject && expectKey {
+			expectKey = false
+			if !secretFields[strings.ToLower(key)] {
+				continue
+			}
+			start := dec.InputOffset()
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+			end := dec.InputOffset()
+			for start < end && (b[start] == ':' || b[start] == ' ' || b[start] == '\t' || b[start] == '\n' || b[start] == '\r') {
+				start++
+			}
+			if v := string(value); v != "null" && v != `""` {
+				spans = append(spans, [2]int64{start, end})
+			}
+			expectKey = true
+			continue
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			objects = append(objects, tok == json.Delim('{'))
+			expectKey = tok == json.Delim('{')
+		case json.Delim('}'), json.Delim(']'):
+			objects = objects[:len(objects)-1]
+			expectKey = len(objects) > 0 && objects[len(objects)-1]
+		default:
+			expectKey = inObject
+		}
+	}
+}
+
+// redactedError is an error whose message has its secrets masked, the original error is kept
+// for errors.Is and errors.As.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError masks the secrets in the message of err.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*redactedError); ok {
+		return err
+	}
+	return &redactedError{err: err, msg: redactString(err.Error())}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProjectConfigChanges lists the entries added, removed and changed by a project config reload.
+type ProjectConfigChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// diffJenkinsProjectConfig compares two project configs by entry name.
+func diffJenkinsProjectConfig(old, new map[string]JenkinsProjectConfig) ProjectConfigChanges {
+	changes := ProjectConfigChanges{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for name, config := range new {
+		if oldConfig, ok := old[name]; !ok {
+			changes.Added = append(changes.Added, name)
+		} else if oldConfig != config {
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	return changes
+}
+
+// projectConfigReloadMu serializes reloads, so each one is compared with the config it replaces.
+var projectConfigReloadMu sync.Mutex
+
+// reloadJenkinsProjectConfig loads the project config again and logs what changed. An invalid
+// config is rejected and the current one is kept. trigger tells what asked for the reload.
+func reloadJenkinsProjectConfig(filename, trigger string) (ProjectConfigChanges, error) {
+	projectConfigReloadMu.Lock()
+	defer projectConfigReloadMu.Unlock()
+	old := jenkinsProjectConfigs()
+	if err := loadJenkinsProjectConfig(filename); err != nil {
+		logger.Error("reload jenkins project config failed, keep the current one", "trigger", trigger, "error", err)
+		return ProjectConfigChanges{}, err
+	}
+	changes := diffJenkinsProjectConfig(old, jenkinsProjectConfigs())
+	logger.Info("jenkins project config reloaded", "trigger", trigger,
+		"added", strings.Join(changes.Added, ","),
+		"removed", strings.Join(changes.Removed, ","),
+		"changed", strings.Join(changes.Changed, ","))
+	return changes, nil
+}
+
+// reloadProjectConfigOnSignal reloads the project config when prcd receives SIGHUP.
+func reloadProjectConfigOnSignal(filename string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reloadJenkinsProjectConfig(filename, "sighup")
+		}
+	}()
+}
+
+// projectConfigSignature identifies the state of the project config files by their names,
+// modification times and sizes.
+func projectConfigSignature(filename string) string {
+	files, _ := projectConfigFiles(filename)
+	var signature strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&signature, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return signature.String()
+}
+
+// watchJenkinsProjectConfig reloads the project config whenever one of its files is changed, added
+// or removed, checking every interval until stop is closed.
+func watchJenkinsProjectConfig(filename string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := projectConfigSignature(filename)
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		if signature := projectConfigSignature(filename); signature != last {
+			last = signature
+			reloadJenkinsProjectConfig(filename, "file change")
+		}
+	}
+}
+
+// isAdminRequest returns true if the request presents settings.adminToken as a bearer token, it
+// answers 401 otherwise.
+func isAdminRequest(c *gin.Context) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if settings.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(settings.adminToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"errmsg": "invalid admin token"})
+		return false
+	}
+	return true
+}
+
+// onReloadProjects reloads the project config for an admin presenting settings.adminToken as a
+// bearer token.
+func onReloadProjects(c *gin.Context) {
+	if !isAdminRequest(c) {
+		return
+	}
+	changes, err := reloadJenkinsProjectConfig(settings.jenkinsProjectConfigFile, "admin")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"errmsg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
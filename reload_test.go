@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestProjectConfig copies the sample project config with replacements.
+func writeTestProjectConfig(t *testing.T, filename string, replacements ...string) {
+	b, err := ioutil.ReadFile("config/projects.sample.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.NewReplacer(replacements...).Replace(string(b))
+	if err := ioutil.WriteFile(filename, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadJenkinsProjectConfig(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	writeTestProjectConfig(t, filename)
+	if err := loadJenkinsProjectConfig(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestProjectConfig(t, filename, "dev-backend-php7:", "dev-backend-php8:", "production-backend-release", "production-backend")
+	changes, err := reloadJenkinsProjectConfig(filename, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProjectConfigChanges{Added: []string{"dev-backend-php8"}, Removed: []string{"dev-backend-php7"},
+		Changed: []string{"release-backend"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Reload changes expected %+v, actual %+v", expected, changes)
+	}
+	if matchJenkinsProject("production", "mimixiche-backend", "release").Name != "production-backend" {
+		t.Error("The reloaded config should be matched")
+	}
+
+	writeTestProjectConfig(t, filename, "jenkins_token:", "jenkins_tokn:")
+	if _, err := reloadJenkinsProjectConfig(filename, "test"); err == nil {
+		t.Error("An invalid config should not be reloaded")
+	}
+	if matchJenkinsProject("production", "mimixiche-backend", "release").Name != "production-backend" {
+		t.Error("The current config should be kept when a reload fails")
+	}
+}
+
+func TestReloadJenkinsProjectConfig_Concurrent(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reloadJenkinsProjectConfig("config/projects.sample.yaml", "test")
+		}()
+		go func() {
+			defer wg.Done()
+			if matchJenkinsProject("debug", "mimixiche-backend", "develop").Name != "dev-jenkins-project" {
+				t.Error("Matching should see a complete config while reloading")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func waitForProjectMatch(t *testing.T, branch, expected string) {
+	for i := 0; i < 200; i++ {
+		if matchJenkinsProject("production", "mimixiche-backend", branch).Name == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("The project config should be reloaded to match %s", expected)
+}
+
+func TestReloadProjectConfigOnSignal(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	writeTestProjectConfig(t, filename)
+	loadJenkinsProjectConfig(filename)
+	reloadProjectConfigOnSignal(filename)
+	writeTestProjectConfig(t, filename, "production-backend-release", "production-backend-hup")
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitForProjectMatch(t, "release", "production-backend-hup")
+}
+
+// startTestWatch watches the project config and returns a func stopping the watch once it is done.
+func startTestWatch(filename string) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		watchJenkinsProjectConfig(filename, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func TestWatchJenkinsProjectConfig(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	writeTestProjectConfig(t, filename)
+	loadJenkinsProjectConfig(filename)
+	stopWatch := startTestWatch(filename)
+	defer stopWatch()
+	time.Sleep(20 * time.Millisecond)
+	writeTestProjectConfig(t, filename, "production-backend-release", "production-backend-watched")
+	waitForProjectMatch(t, "release", "production-backend-watched")
+}
+
+func TestOnReloadProjects(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	saved := settings
+	defer func() { settings = saved }()
+	settings.adminToken, settings.jenkinsProjectConfigFile = "admin-s3cr3t", "config/projects.sample.yaml"
+	r := createGinEngine()
+	r.POST("/admin/reload-projects", onReloadProjects)
+	reload := func(token string) int {
+		req := httptest.NewRequest("POST", "/admin/reload-projects", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := reload(""); code != http.StatusUnauthorized {
+		t.Errorf("Reload without a token should be rejected, actual %d", code)
+	}
+	if code := reload("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Reload with a wrong token should be rejected, actual %d", code)
+	}
+	if code := reload("admin-s3cr3t"); code != http.StatusOK {
+		t.Errorf("Reload with the admin token should succeed, actual %d", code)
+	}
+	settings.jenkinsProjectConfigFile = "config/not-exists.yaml"
+	if code := reload("admin-s3cr3t"); code != http.StatusUnprocessableEntity {
+		t.Errorf("A failed reload should be reported, actual %d", code)
+	}
+}
+
+func TestWatchJenkinsProjectConfig_IncludeDir(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "projects.yaml")
+	writeTestProjectConfig(t, filename)
+	os.Mkdir(filepath.Join(dir, "projects.d"), 0750)
+	loadJenkinsProjectConfig(filename)
+	stopWatch := startTestWatch(filename)
+	defer stopWatch()
+	time.Sleep(20 * time.Millisecond)
+	ioutil.WriteFile(filepath.Join(dir, "projects.d", "mingdao.yaml"), []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mimixiche-backend
+  branch: master
+  jenkins_project: production-mingdao
+  jenkins_token: abcd1234
+`), 0640)
+	waitForProjectMatch(t, "master", "production-mingdao")
+}
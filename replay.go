@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of the replay subcommand.
+const (
+	ReplayFromArchive = "archive"
+	ReplayFromLog     = "log"
+)
+
+// HookDelivery is a received hook payload with what is needed to replay it, it is the format of
+// the files of the hook archive directory.
+type HookDelivery struct {
+	CorrelationId string          `json:"correlation_id"`
+	HookName      string          `json:"hook_name"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+var archiveNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// archiveHook writes a delivery to settings.hookArchiveDir, one file named by its time and
+// correlation id. Nothing is written if the archive is disabled.
+func archiveHook(log Logger, delivery HookDelivery) {
+	if settings.hookArchiveDir == "" {
+		return
+	}
+	b, err := json.Marshal(delivery)
+	if err == nil {
+		name := delivery.ReceivedAt.UTC().Format("20060102T150405.000000000Z") + "-" +
+			archiveNamePattern.ReplaceAllString(delivery.CorrelationId, "_") + ".json"
+		err = ioutil.WriteFile(filepath.Join(settings.hookArchiveDir, name), b, 0600)
+	}
+	if err != nil {
+		log.Error("archive hook failed", "error", err)
+	}
+}
+
+// readArchivedHooks reads the deliveries of a hook archive directory.
+func readArchivedHooks(dir string) ([]HookDelivery, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []HookDelivery
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var delivery HookDelivery
+		if err := json.Unmarshal(b, &delivery); err != nil {
+			return nil, fmt.Errorf("read archived hook %s: %v", file, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// readLoggedHooks reads the deliveries of a message log and of its rotated files, in text or JSON
+// format. Payloads are the "receive post" records, only written with -verbose, and their secret
+// fields are masked. The hook name is taken from the "received hook" record.
+func readLoggedHooks(filename string) ([]HookDelivery, error) {
+	rotated, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []HookDelivery
+	for _, file := range append(rotated, filename) {
+		fileDeliveries, err := readLogFileHooks(file)
+		if os.IsNotExist(err) && file == filename && len(rotated) > 0 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, fileDeliveries...)
+	}
+	if len(deliveries) == 0 {
+		return nil, fmt.Errorf("no hook payload in %s, payloads are only logged with -verbose", filename)
+	}
+	return deliveries, nil
+}
+
+func readLogFileHooks(file string) ([]HookDelivery, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %v", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var deliveries []HookDelivery
+	// pending are the payloads waiting for their "received hook" record, by correlation id.
+	// Duplicates dropped by the dedup check have none and are not replayed.
+	pending := make(map[string]HookDelivery)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record, ok := parseLogRecord(scanner.Text())
+		if !ok || record.correlationId == "" {
+			continue
+		}
+		switch record.msg {
+		case "receive post":
+			if json.Valid([]byte(record.fields["payload"])) {
+				pending[record.correlationId] = HookDelivery{CorrelationId: record.correlationId, ReceivedAt: record.time,
+					Payload: json.RawMessage(record.fields["payload"])}
+			}
+		case "received hook":
+			if delivery, ok := pending[record.correlationId]; ok {
+				delivery.HookName = record.fields["hook_name"]
+				deliveries = append(deliveries, delivery)
+				delete(pending, record.correlationId)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %v", file, err)
+	}
+	return deliveries, nil
+}
+
+// logRecord is a message log record, fields only holds the ones replay reads.
+type logRecord struct {
+	time          time.Time
+	correlationId string
+	msg           string
+	fields        map[string]string
+}
+
+var textLogPattern = regexp.MustCompile(`^(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d) \[[^\]]*\] \[\w+\] (receive post|received hook) (.*) correlation_id=(\S+)$`)
+
+// parseLogRecord parses the "receive post" and "received hook" records written by formatLogRecord.
+func parseLogRecord(line string) (logRecord, bool) {
+	if strings.HasPrefix(line, "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return logRecord{}, false
+		}
+		record := logRecord{fields: make(map[string]string)}
+		for key, value := range fields {
+			if s, ok := value.(string); ok {
+				record.fields[key] = s
+			}
+		}
+		record.msg, record.correlationId = record.fields["msg"], record.fields["correlation_id"]
+		record.time, _ = time.Parse(time.RFC3339Nano, record.fields["time"])
+		return record, true
+	}
+
+	m := textLogPattern.FindStringSubmatch(line)
+	if m == nil {
+		return logRecord{}, false
+	}
+	record := logRecord{msg: m[2], correlationId: m[4], fields: make(map[string]string)}
+	record.time, _ = time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local)
+	key := "payload"
+	if record.msg == "received hook" {
+		key = "hook_name"
+	}
+	prefix := key + "="
+	i := strings.Index(m[3], prefix)
+	if i < 0 || (i > 0 && m[3][i-1] != ' ') {
+		return record, true
+	}
+	value := m[3][i+len(prefix):]
+	if record.msg == "received hook" {
+		// hook_name is followed by hook_id.
+		if j := strings.LastIndex(value, " hook_id="); j >= 0 {
+			value = value[:j]
+		}
+	}
+	if strings.HasPrefix(value, `"`) {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+	}
+	record.fields[key] = value
+	return record, true
+}
+
+// replayFilter selects the deliveries to replay, zero fields select every delivery.
+type replayFilter struct {
+	since, until   time.Time
+	project        string
+	correlationIds map[string]bool
+}
+
+func (filter *replayFilter) match(delivery HookDelivery) bool {
+	return (filter.since.IsZero() || !delivery.ReceivedAt.Before(filter.since)) &&
+		(filter.until.IsZero() || delivery.ReceivedAt.Before(filter.until)) &&
+		(filter.correlationIds == nil || filter.correlationIds[delivery.CorrelationId])
+}
+
+// parseReplayTime reads a time as RFC 3339 or as a duration before now, e.g. 2h.
+func parseReplayTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// historyCorrelationIds returns the correlation ids of the deployment history records with a
+// status, received in the time range of the filter.
+func historyCorrelationIds(filename, status string, filter replayFilter) (map[string]bool, error) {
+	store, err := openHistoryStore(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open history %s: %v, replay from a copy while prcd runs", filename, err)
+	}
+	defer store.Close()
+	records, _, err := store.Query(DeploymentFilter{Status: status, Since: filter.since, Until: filter.until,
+		PerPage: 1 << 30})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, record := range records {
+		ids[record.CorrelationId] = true
+	}
+	return ids, nil
+}
+
+// payloadMasked reports whether a payload had secret fields masked when it was logged, replaying
+// it would send the mask instead of the secret.
+func payloadMasked(payload []byte) bool {
+	return bytes.Contains(payload, []byte(`"`+redactedValue+`"`))
+}
+
+// replayHook posts a delivery to the notify url of a running prcd and returns the correlation id
+// it answered with. The GitHub event is sent in X-GitHub-Event for payloads without hook_name,
+// the delivery is signed with settings.webhookSecret and marked as a replay so that it is not
+// dropped as a duplicate of the original.
+func replayHook(target, correlationId string, delivery HookDelivery) (string, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Id", correlationId)
+	req.Header.Set(replayHeader, delivery.CorrelationId)
+	signHook(req, delivery, settings.webhookSecret)
+	if event := githubEventOf(delivery); event != "" {
+		req.Header.Set("X-GitHub-Event", event)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", redactError(err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Errcode       int    `json:"errcode"`
+		Errmsg        string `json:"errmsg"`
+		CorrelationId string `json:"correlation_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || resp.StatusCode != http.StatusOK {
+		return "", errors.New("replay failed: status " + resp.Status)
+	}
+	if response.Errcode != 0 {
+		return response.CorrelationId, fmt.Errorf("replay failed: errcode %d %s", response.Errcode, response.Errmsg)
+	}
+	return response.CorrelationId, nil
+}
+
+// runReplay is the replay subcommand, it replays recorded hook deliveries oldest first, either by
+// posting them to a running prcd or through the pipeline in this process. It takes the server
+// settings, -config and PRCD_* environment variables as the server does, to find the archive, the
+// message log, the history and the project config. With -dry-run it only lists the deliveries and
+// the entries they match.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	registerSettingFlags(flags)
+	source := flags.String("source", "", "Where to read the payloads, archive or log, archive if -hook-archive-dir is set.")
+	since := flags.String("since", "", "Replay the deliveries received since this time, RFC 3339 or a duration before now, e.g. 2h.")
+	until := flags.String("until", "", "Replay the deliveries received before this time, RFC 3339 or a duration before now.")
+	project := flags.String("project", "", "Replay the deliveries of this VCS project.")
+	correlationIds := flags.String("correlation-id", "", "Comma separated correlation ids of the deliveries to replay.")
+	status := flags.String("status", "", "Replay the deliveries whose deployment history record has this status, e.g. failed. History records hold no payload, the payloads are read from -source.")
+	target := flags.String("target", "", "Notify url of a running prcd to post the deliveries to, e.g. http://127.0.0.1:8889/notify.")
+	local := flags.Bool("local", false, "Run the deliveries through the pipeline in this process, without history and audit log.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: prcd replay [options] (-target <notify url> | -local)")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || (*target == "") == !*local {
+		flags.Usage()
+		return 2
+	}
+	if _, err := applySettingSources(flags, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := resolveSecretSettings(flags); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSecrets(settings.jenkinsUserApiToken, settings.githubToken, settings.giteeToken, settings.adminToken,
+		settings.webhookSecret)
+	// Keep stdout for the replay report.
+	logOutput = os.Stderr
+
+	filter := replayFilter{project: *project}
+	var err error
+	if filter.since, err = parseReplayTime(*since); err == nil {
+		filter.until, err = parseReplayTime(*until)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid time:", err)
+		return 2
+	}
+	if *correlationIds != "" {
+		filter.correlationIds = make(map[string]bool)
+		for _, id := range strings.Split(*correlationIds, ",") {
+			filter.correlationIds[strings.TrimSpace(id)] = true
+		}
+	}
+	if *status != "" {
+		ids, err := historyCorrelationIds(settings.historyDbFile, *status, filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if filter.correlationIds != nil {
+			for id := range filter.correlationIds {
+				filter.correlationIds[id] = ids[id]
+			}
+		} else {
+			filter.correlationIds = ids
+		}
+	}
+
+	if *source == "" {
+		*source = ReplayFromLog
+		if settings.hookArchiveDir != "" {
+			*source = ReplayFromArchive
+		}
+	}
+	var deliveries []HookDelivery
+	switch *source {
+	case ReplayFromArchive:
+		deliveries, err = readArchivedHooks(settings.hookArchiveDir)
+	case ReplayFromLog:
+		deliveries, err = readLoggedHooks(settings.hookMessageLogFile)
+	default:
+		err = fmt.Errorf("unknown source %s, use archive or log", *source)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].ReceivedAt.Before(deliveries[j].ReceivedAt) })
+
+	if settings.dryRun || *local {
+		if err := loadJenkinsProjectConfig(settings.jenkinsProjectConfigFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *local && !settings.dryRun {
+		if err := loadNotificationConfig(settings.notificationConfigFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := loadCommandConfig(settings.commandConfigFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	code, replayed := 0, 0
+	for _, delivery := range deliveries {
+		if !filter.match(delivery) {
+			continue
+		}
+		explanation, err := explainMatch(githubEventOf(delivery), delivery.Payload)
+		if err != nil {
+			fmt.Printf("%s %s: invalid payload: %v\n", delivery.ReceivedAt.Format(time.RFC3339), delivery.CorrelationId, err)
+			code = 1
+			continue
+		}
+		if filter.project != "" && explanation.Project != filter.project {
+			continue
+		}
+		fmt.Printf("%s %s %s project=%s branch=%s environment=%s", delivery.ReceivedAt.Format(time.RFC3339),
+			delivery.CorrelationId, explanation.HookName, explanation.Project, explanation.Branch, explanation.Environment)
+		masked := payloadMasked(delivery.Payload)
+		if settings.dryRun {
+			fmt.Printf(" matched=%s", strings.Join(explanation.Matched, ","))
+			if masked {
+				fmt.Print(" warning=masked secrets, would not be replayed")
+			} else {
+				replayed++
+			}
+			fmt.Println()
+			continue
+		}
+		if masked {
+			fmt.Println(" not replayed: the payload has masked secrets, replay it from the archive")
+			code = 1
+			continue
+		}
+		replayed++
+		correlationId := delivery.CorrelationId + "-replay"
+		if *local {
+			basicHook := BasicHook{}
+			json.Unmarshal(delivery.Payload, &basicHook)
+			basicHook.HookName = explanation.HookName
+			sendNotice(context.Background(), correlationId, basicHook, delivery.Payload)
+			fmt.Printf(" replayed correlation_id=%s\n", correlationId)
+			continue
+		}
+		if correlationId, err = replayHook(*target, correlationId, delivery); err != nil {
+			fmt.Printf(" %v\n", err)
+			code = 1
+			continue
+		}
+		fmt.Printf(" posted correlation_id=%s\n", correlationId)
+	}
+	if settings.dryRun {
+		fmt.Printf("%d deliveries would be replayed\n", replayed)
+	} else {
+		fmt.Printf("%d deliveries replayed\n", replayed)
+	}
+	return code
+}
+
+// githubEventOf returns the X-GitHub-Event header of a GitHub delivery, whose payload has no
+// hook_name, or an empty string for other deliveries.
+func githubEventOf(delivery HookDelivery) string {
+	basicHook := BasicHook{}
+	json.Unmarshal(delivery.Payload, &basicHook)
+	if basicHook.HookName != "" || !strings.HasPrefix(delivery.HookName, "github_") {
+		return ""
+	}
+	return strings.TrimPrefix(delivery.HookName, "github_")
+}
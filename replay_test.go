@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveHook(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	settings.hookArchiveDir = t.TempDir()
+	b, _ := ioutil.ReadFile("samples/github_pull_request.json")
+	received := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	archiveHook(logger, HookDelivery{CorrelationId: "a/b", HookName: "github_pull_request", ReceivedAt: received, Payload: b})
+	archiveHook(logger, HookDelivery{CorrelationId: "c", HookName: "merge_request_hooks", ReceivedAt: received.Add(time.Minute),
+		Payload: []byte(`{"hook_name":"merge_request_hooks"}`)})
+
+	var compact bytes.Buffer
+	json.Compact(&compact, b)
+	deliveries, err := readArchivedHooks(settings.hookArchiveDir)
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("Both deliveries should be archived, actual %v %v", deliveries, err)
+	}
+	if d := deliveries[0]; d.CorrelationId != "a/b" || d.HookName != "github_pull_request" || !d.ReceivedAt.Equal(received) ||
+		!bytes.Equal(d.Payload, compact.Bytes()) {
+		t.Errorf("The archived delivery should be read back, actual %+v", d)
+	}
+	if files, _ := filepath.Glob(filepath.Join(settings.hookArchiveDir, "*-a_b.json")); len(files) != 1 {
+		t.Errorf("The correlation id should be safe in the file name, actual %v", files)
+	}
+}
+
+func TestReadLoggedHooks(t *testing.T) {
+	savedFormat := logFormat
+	defer func() { logFormat = savedFormat }()
+	payload := `{"hook_name":"merge_request_hooks","object_attributes":{"title":"fix = \"quotes\""}}`
+	received := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	logLines := func(format string) []byte {
+		logFormat = format
+		var buf bytes.Buffer
+		buf.Write(formatLogRecord(received, LevelDebug, "prcd.go:291", "id-1", "receive post", []interface{}{"payload", payload}))
+		buf.Write(formatLogRecord(received, LevelInfo, "prcd.go:305", "id-1", "received hook",
+			[]interface{}{"hook_name", "merge_request_hooks", "hook_id", 0}))
+		// A duplicate dropped by the dedup check is not replayed.
+		buf.Write(formatLogRecord(received, LevelDebug, "prcd.go:291", "id-2", "receive post", []interface{}{"payload", payload}))
+		buf.Write(formatLogRecord(received, LevelInfo, "prcd.go:298", "id-2", "duplicate webhook payload dropped", nil))
+		buf.Write(formatLogRecord(received, LevelDebug, "prcd.go:291", "id-3", "receive post", []interface{}{"payload", `{"action":"opened"}`}))
+		buf.Write(formatLogRecord(received, LevelInfo, "prcd.go:305", "id-3", "received hook",
+			[]interface{}{"hook_name", "github_pull_request", "hook_id", 0}))
+		return buf.Bytes()
+	}
+
+	for _, format := range []string{LogFormatText, LogFormatJson} {
+		filename := filepath.Join(t.TempDir(), "message.log")
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(logLines(format))
+		w.Close()
+		ioutil.WriteFile(filename+".20261019-120000.gz", gz.Bytes(), 0640)
+		ioutil.WriteFile(filename, logLines(format), 0640)
+
+		deliveries, err := readLoggedHooks(filename)
+		if err != nil || len(deliveries) != 4 {
+			t.Fatalf("%s: the payloads of both files should be read, actual %v %v", format, deliveries, err)
+		}
+		if d := deliveries[0]; d.CorrelationId != "id-1" || d.HookName != "merge_request_hooks" ||
+			string(d.Payload) != payload || !d.ReceivedAt.Equal(received) {
+			t.Errorf("%s: the delivery should be read back, actual %+v %s", format, d, d.Payload)
+		}
+		if d := deliveries[1]; d.CorrelationId != "id-3" || d.HookName != "github_pull_request" {
+			t.Errorf("%s: the GitHub delivery should have its hook name, actual %+v", format, d)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "message.log")
+	ioutil.WriteFile(filename, []byte("2026/10/19 12:00:00 [prcd.go:305] [Info] received hook hook_name=x hook_id=0 correlation_id=id-1\n"), 0640)
+	if _, err := readLoggedHooks(filename); err == nil || !strings.Contains(err.Error(), "-verbose") {
+		t.Errorf("A log without payloads should be reported, actual %v", err)
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	saved, output := settings, logOutput
+	defer func() { settings, logOutput = saved, output }()
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	archive := t.TempDir()
+	settings.hookArchiveDir = archive
+	github, _ := ioutil.ReadFile("samples/github_pull_request.json")
+	gitlab, _ := ioutil.ReadFile("samples/pull_request.json")
+	received := time.Now().Add(-time.Hour)
+	archiveHook(logger, HookDelivery{CorrelationId: "gh-1", HookName: "github_pull_request", ReceivedAt: received, Payload: github})
+	archiveHook(logger, HookDelivery{CorrelationId: "gl-1", HookName: "merge_request_hooks", ReceivedAt: received.Add(time.Minute),
+		Payload: gitlab})
+	archiveHook(logger, HookDelivery{CorrelationId: "gl-0", HookName: "merge_request_hooks", ReceivedAt: received.Add(-48 * time.Hour),
+		Payload: gitlab})
+
+	archiveHook(logger, HookDelivery{CorrelationId: "gl-masked", HookName: "merge_request_hooks", ReceivedAt: received.Add(-25 * time.Hour),
+		Payload: []byte(`{"hook_name":"merge_request_hooks","password":"******"}`)})
+
+	var requests []*http.Request
+	var signed []bool
+	prcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		body, _ := ioutil.ReadAll(r.Body)
+		signed = append(signed, verifyHookSignature(r.Header.Get, body))
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","correlation_id":"` + r.Header.Get("X-Request-Id") + `"}`))
+	}))
+	defer prcd.Close()
+
+	args := []string{"-hook-archive-dir", archive, "-history-db-file", "", "-since", "24h"}
+	if code := runReplay(append(args, "-target", prcd.URL+"/notify")); code != 0 || len(requests) != 2 {
+		t.Fatalf("The deliveries of the last day should be posted, actual exit code %d, %d requests", code, len(requests))
+	}
+	if r := requests[0]; r.Header.Get("X-GitHub-Event") != "pull_request" || r.Header.Get("X-Request-Id") != "gh-1-replay" {
+		t.Errorf("The GitHub delivery should be posted first with its event, actual %v", r.Header)
+	}
+	if r := requests[1]; r.Header.Get("X-GitHub-Event") != "" || r.Header.Get("X-Request-Id") != "gl-1-replay" {
+		t.Errorf("The GitLab delivery should be posted without event header, actual %v", r.Header)
+	}
+	if requests[0].Header.Get(replayHeader) != "gh-1" || requests[1].Header.Get(replayHeader) != "gl-1" {
+		t.Errorf("Replays should be marked with the original correlation id, actual %v", requests)
+	}
+
+	requests, signed = nil, nil
+	if code := runReplay(append(args, "-webhook-secret", "hook-key", "-target", prcd.URL+"/notify")); code != 0 ||
+		len(signed) != 2 || !signed[0] || !signed[1] {
+		t.Errorf("Replays should be signed with the webhook secret, actual exit code %d, signed %v", code, signed)
+	}
+	if requests[0].Header.Get(githubSignatureHeader) == "" || requests[1].Header.Get(gitlabTokenHeader) != "hook-key" {
+		t.Errorf("Replays should be signed the way their VCS does, actual %v %v", requests[0].Header, requests[1].Header)
+	}
+	settings.webhookSecret = ""
+
+	requests = nil
+	if code := runReplay([]string{"-hook-archive-dir", archive, "-history-db-file", "", "-correlation-id", "gl-masked",
+		"-target", prcd.URL + "/notify"}); code != 1 || len(requests) != 0 {
+		t.Errorf("A payload with masked secrets should not be replayed, actual exit code %d, %d requests", code, len(requests))
+	}
+
+	requests = nil
+	if code := runReplay(append(args, "-correlation-id", "gl-1", "-target", prcd.URL+"/notify")); code != 0 || len(requests) != 1 {
+		t.Errorf("Only the delivery of the correlation id should be posted, actual exit code %d, %d requests", code, len(requests))
+	}
+	requests = nil
+	if code := runReplay(append(args, "-project", "not-exists", "-target", prcd.URL+"/notify")); code != 0 || len(requests) != 0 {
+		t.Errorf("No delivery of another project should be posted, actual exit code %d, %d requests", code, len(requests))
+	}
+	requests = nil
+	if code := runReplay(append(args, "-dry-run", "-jenkins-project-config-file", "config/projects.sample.yaml",
+		"-target", prcd.URL+"/notify")); code != 0 || len(requests) != 0 {
+		t.Errorf("A dry run should post nothing, actual exit code %d, %d requests", code, len(requests))
+	}
+
+	historyDb := filepath.Join(t.TempDir(), "history.db")
+	store, _ := openHistoryStore(historyDb)
+	store.Save(&DeploymentRecord{CorrelationId: "gh-1", Status: DeploySucceeded, ReceivedAt: received})
+	store.Save(&DeploymentRecord{CorrelationId: "gl-1", Status: DeployFailed, ReceivedAt: received.Add(time.Minute)})
+	store.Close()
+	requests = nil
+	if code := runReplay([]string{"-hook-archive-dir", archive, "-history-db-file", historyDb, "-status", DeployFailed,
+		"-target", prcd.URL + "/notify"}); code != 0 || len(requests) != 1 || requests[0].Header.Get("X-Request-Id") != "gl-1-replay" {
+		t.Errorf("Only the failed delivery should be posted, actual exit code %d, %d requests", code, len(requests))
+	}
+
+	var notified []string
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified = append(notified, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer jenkins.Close()
+	config := filepath.Join(t.TempDir(), "projects.yaml")
+	ioutil.WriteFile(config, []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+`), 0640)
+	if code := runReplay(append(args, "-local", "-correlation-id", "gh-1", "-jenkins-project-config-file", config,
+		"-jenkins-host", jenkins.URL)); code != 0 || len(notified) != 1 || notified[0] != "/job/pro/build" {
+		t.Errorf("The delivery should be run through the local pipeline, actual exit code %d, notified %v", code, notified)
+	}
+
+	if code := runReplay(args); code != 2 {
+		t.Errorf("replay should require -target or -local, actual exit code %d", code)
+	}
+	if code := runReplay([]string{"-source", "log", "-message-log-file", "not-exists.log", "-local"}); code != 1 {
+		t.Errorf("replay should fail without a message log, actual exit code %d", code)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider resolves the secret references of a scheme, e.g. "env" for ${env:JENKINS_TOKEN}.
+// Errors must not carry the secret.
+type SecretProvider interface {
+	Resolve(ref string) (string, error)
+}
+
+var (
+	secretProviders = map[string]SecretProvider{
+		"env":   envSecretProvider{},
+		"file":  fileSecretProvider{},
+		"vault": vaultSecretProvider{},
+	}
+	secretProvidersMu sync.RWMutex
+)
+
+// registerSecretProvider adds or replaces the provider of a scheme.
+func registerSecretProvider(scheme string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[scheme] = provider
+}
+
+func findSecretProvider(scheme string) (SecretProvider, bool) {
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	provider, ok := secretProviders[scheme]
+	return provider, ok
+}
+
+var (
+	secretReferencePattern     = regexp.MustCompile(`^\$\{([a-z][a-z0-9_-]*):(.+)\}$`)
+	bareSecretReferencePattern = regexp.MustCompile(`^([a-z][a-z0-9_-]*):.`)
+)
+
+// parseSecretReference splits a ${scheme:ref} value. Any other value, e.g. a token that looks
+// like env:NAME, is not a reference.
+func parseSecretReference(value string) (scheme, ref string, ok bool) {
+	m := secretReferencePattern.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// checkSecretReference returns an error if value refers to a scheme without provider, or looks
+// like a reference without its ${...}, e.g. file:/run/secrets/x, which would be used as plain
+// text. The error does not carry the value, which may be a secret.
+func checkSecretReference(value string) error {
+	if scheme, _, ok := parseSecretReference(value); ok {
+		if _, found := findSecretProvider(scheme); !found {
+			return fmt.Errorf("unknown secret provider %s", scheme)
+		}
+	} else if m := bareSecretReferencePattern.FindStringSubmatch(value); m != nil {
+		if _, found := findSecretProvider(m[1]); found {
+			return fmt.Errorf("%s: secret reference without ${...}, write it as ${%s:...} or it is used as plain text", m[1], m[1])
+		}
+	}
+	return nil
+}
+
+// resolveSecret returns the secret a value refers to, or the value itself if it is not a
+// reference. Resolved secrets are registered to be masked in logs.
+func resolveSecret(value string) (string, error) {
+	scheme, ref, ok := parseSecretReference(value)
+	if !ok {
+		return value, nil
+	}
+	provider, found := findSecretProvider(scheme)
+	if !found {
+		return "", fmt.Errorf("unknown secret provider %s", scheme)
+	}
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("resolve secret %s:%s: %v", scheme, ref, redactError(err))
+	}
+	registerSecrets(secret)
+	return secret, nil
+}
+
+// envSecretProvider reads secrets from environment variables.
+type envSecretProvider struct{}
+
+func (envSecretProvider) Resolve(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("environment variable is not set")
+	}
+	return value, nil
+}
+
+// fileSecretProvider reads secrets from files such as Docker or Kubernetes secrets, a trailing
+// newline is dropped.
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Resolve(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// vaultSecretProvider reads secrets from a HashiCorp Vault KV secrets engine, version 1 or 2. A
+// reference is the API path of the secret and a key, e.g. secret/data/prcd#jenkins_token. The
+// address and token are settings.vaultAddr and settings.vaultToken, or VAULT_ADDR and VAULT_TOKEN.
+type vaultSecretProvider struct{}
+
+func (vaultSecretProvider) Resolve(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return "", errors.New("vault reference should be <path>#<key>")
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+	addr, token := settings.vaultAddr, settings.vaultToken
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if addr == "" {
+		return "", errors.New("vault address is not configured")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("vault returned " + resp.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// KV version 2 nests the secret under data with its metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret has no string key %s", key)
+	}
+	return value, nil
+}
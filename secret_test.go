@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("PRCD_TEST_JENKINS_TOKEN", "env-s3cr3t")
+	defer os.Unsetenv("PRCD_TEST_JENKINS_TOKEN")
+	secretFile := filepath.Join(t.TempDir(), "jenkins_token")
+	ioutil.WriteFile(secretFile, []byte("file-s3cr3t\n"), 0600)
+
+	testData := map[string]string{
+		"abcd1234":                       "abcd1234",
+		"plain:token":                    "plain:token",
+		"${env:PRCD_TEST_JENKINS_TOKEN}": "env-s3cr3t",
+		"env:PRCD_TEST_JENKINS_TOKEN":    "env:PRCD_TEST_JENKINS_TOKEN",
+		"file:" + secretFile:             "file:" + secretFile,
+		"${file:" + secretFile + "}":     "file-s3cr3t",
+	}
+	for value, expected := range testData {
+		if actual, err := resolveSecret(value); err != nil || actual != expected {
+			t.Errorf("Resolve %s, expected %s, actual %s %v", value, expected, actual, err)
+		}
+	}
+	if redactString("token env-s3cr3t") != "token ******" {
+		t.Error("Resolved secrets should be masked in logs")
+	}
+	for _, value := range []string{"${env:PRCD_TEST_NOT_SET}", "${file:/not/exists}", "${nope:x}"} {
+		if _, err := resolveSecret(value); err == nil {
+			t.Errorf("Resolving %s should fail", value)
+		}
+	}
+}
+
+// vaultTestServer serves a KV version 2 secret at secret/data/prcd and a version 1 one at kv/prcd.
+func vaultTestServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/prcd":
+			w.Write([]byte(`{"data":{"data":{"jenkins_token":"vault-s3cr3t"},"metadata":{"version":1}}}`))
+		case "/v1/kv/prcd":
+			w.Write([]byte(`{"data":{"jenkins_token":"vault-v1-s3cr3t"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	saved := settings
+	defer func() { settings = saved }()
+	settings.vaultAddr, settings.vaultToken = vaultTestServer(t).URL, "vault-root"
+
+	testData := map[string]string{
+		"${vault:secret/data/prcd#jenkins_token}": "vault-s3cr3t",
+		"${vault:kv/prcd#jenkins_token}":          "vault-v1-s3cr3t",
+	}
+	for value, expected := range testData {
+		if actual, err := resolveSecret(value); err != nil || actual != expected {
+			t.Errorf("Resolve %s, expected %s, actual %s %v", value, expected, actual, err)
+		}
+	}
+	for _, value := range []string{"${vault:secret/data/prcd#missing}", "${vault:secret/data/other#jenkins_token}", "${vault:secret/data/prcd}"} {
+		if _, err := resolveSecret(value); err == nil {
+			t.Errorf("Resolving %s should fail", value)
+		}
+	}
+	settings.vaultToken = "wrong"
+	if _, err := resolveSecret("${vault:secret/data/prcd#jenkins_token}"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("A rejected token should fail, actual %v", err)
+	}
+}
+
+// TestVaultSecretProvider_DevServer runs against a Vault dev server, e.g.
+// vault server -dev -dev-root-token-id=root, if PRCD_TEST_VAULT_ADDR and PRCD_TEST_VAULT_TOKEN are set.
+func TestVaultSecretProvider_DevServer(t *testing.T) {
+	addr, token := os.Getenv("PRCD_TEST_VAULT_ADDR"), os.Getenv("PRCD_TEST_VAULT_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("PRCD_TEST_VAULT_ADDR and PRCD_TEST_VAULT_TOKEN are not set")
+	}
+	saved := settings
+	defer func() { settings = saved }()
+	settings.vaultAddr, settings.vaultToken = addr, token
+
+	b, _ := json.Marshal(map[string]interface{}{"data": map[string]string{"jenkins_token": "dev-server-s3cr3t"}})
+	req, _ := http.NewRequest("POST", strings.TrimSuffix(addr, "/")+"/v1/secret/data/prcd-test", bytes.NewReader(b))
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if actual, err := resolveSecret("${vault:secret/data/prcd-test#jenkins_token}"); err != nil || actual != "dev-server-s3cr3t" {
+		t.Errorf("The secret should be read from the dev server, actual %s %v", actual, err)
+	}
+}
+
+func TestLoadJenkinsProjectConfig_SecretReferences(t *testing.T) {
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	saved := settings
+	defer func() { settings = saved }()
+	settings.vaultAddr, settings.vaultToken = vaultTestServer(t).URL, "vault-root"
+	os.Setenv("PRCD_TEST_USER_API_TOKEN", "user-api-s3cr3t")
+	defer os.Unsetenv("PRCD_TEST_USER_API_TOKEN")
+
+	filename := filepath.Join(t.TempDir(), "projects.yaml")
+	config := `
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: ${vault:secret/data/prcd#jenkins_token}
+  jenkins_host: http://project-jenkins.com
+  jenkins_url: /job/<project>/build?token=<token>
+  jenkins_username: akimimi
+  jenkins_user_api_token: ${env:PRCD_TEST_USER_API_TOKEN}
+`
+	ioutil.WriteFile(filename, []byte(config), 0640)
+	if err := loadJenkinsProjectConfig(filename); err != nil {
+		t.Fatal(err)
+	}
+	if project := matchJenkinsProject("production", "mingdao", "master"); project.Token != "vault-s3cr3t" ||
+		project.UserApiToken != "user-api-s3cr3t" {
+		t.Errorf("Secret references should be resolved at load, actual %+v", project)
+	}
+
+	ioutil.WriteFile(filename, []byte(strings.Replace(config, "PRCD_TEST_USER_API_TOKEN", "PRCD_TEST_NOT_SET", 1)), 0640)
+	if err := loadJenkinsProjectConfig(filename); err == nil || !strings.Contains(err.Error(), "entry release-mingdao") {
+		t.Errorf("An unresolvable reference should fail the load, actual %v", err)
+	}
+	if matchJenkinsProject("production", "mingdao", "master").Token != "vault-s3cr3t" {
+		t.Error("The current config should be kept when a reference cannot be resolved")
+	}
+
+	ioutil.WriteFile(filename, []byte(strings.Replace(config, "${env:", "${nope:", 1)), 0640)
+	if _, problems, _ := readJenkinsProjectConfig(filename); len(problems) != 1 ||
+		!strings.Contains(problems[0], "jenkins_user_api_token: unknown secret provider nope") {
+		t.Errorf("A reference to an unknown provider should be invalid, actual %v", problems)
+	}
+
+	ioutil.WriteFile(filename, []byte(strings.Replace(config, "${env:PRCD_TEST_USER_API_TOKEN}", "file:/run/secrets/x", 1)), 0640)
+	if _, problems, _ := readJenkinsProjectConfig(filename); len(problems) != 1 ||
+		!strings.Contains(problems[0], "jenkins_user_api_token: file: secret reference without ${...}") {
+		t.Errorf("A bare file: reference should be reported, actual %v", problems)
+	}
+	if code := runValidateConfig([]string{filename}); code != 1 {
+		t.Errorf("validate-config should fail on a bare file: reference, actual exit code %d", code)
+	}
+}
+
+func TestResolveSecretSettings(t *testing.T) {
+	os.Setenv("PRCD_TEST_GITHUB_TOKEN", "github-s3cr3t")
+	defer os.Unsetenv("PRCD_TEST_GITHUB_TOKEN")
+	var githubToken, jenkinsHost string
+	flags := flag.NewFlagSet("prcd", flag.ContinueOnError)
+	flags.StringVar(&githubToken, "github-token", "", "")
+	flags.StringVar(&jenkinsHost, "jenkins-host", "", "")
+	flags.Parse([]string{"-github-token", "${env:PRCD_TEST_GITHUB_TOKEN}", "-jenkins-host", "${env:PRCD_TEST_GITHUB_TOKEN}"})
+	if err := resolveSecretSettings(flags); err != nil {
+		t.Fatal(err)
+	}
+	if githubToken != "github-s3cr3t" || jenkinsHost != "${env:PRCD_TEST_GITHUB_TOKEN}" {
+		t.Errorf("Only secret settings should be resolved, actual %s, %s", githubToken, jenkinsHost)
+	}
+	flags.Set("github-token", "${env:PRCD_TEST_NOT_SET}")
+	if err := resolveSecretSettings(flags); err == nil {
+		t.Error("An unresolvable secret setting should fail")
+	}
+	flags.Set("github-token", "file:/run/secrets/github_token")
+	if err := resolveSecretSettings(flags); err == nil || strings.Contains(err.Error(), "github_token") {
+		t.Errorf("A bare file: reference should fail without printing the value, actual %v", err)
+	}
+}
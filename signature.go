@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// Webhook signature headers. GitHub signs the payload with the secret, GitLab and Gitee send the
+// secret token itself.
+const (
+	githubSignatureHeader = "X-Hub-Signature-256"
+	gitlabTokenHeader     = "X-Gitlab-Token"
+	giteeTokenHeader      = "X-Gitee-Token"
+)
+
+// replayHeader marks a delivery posted by prcd replay, its value is the original correlation id.
+// A replay is not dropped as a duplicate of the original delivery if it is signed, that is if
+// settings.webhookSecret is set, the header is ignored otherwise.
+const replayHeader = "X-Prcd-Replay"
+
+// githubSignature returns the X-Hub-Signature-256 value of a payload.
+func githubSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHookSignature checks the signature of a received hook against settings.webhookSecret:
+// the GitHub payload signature, or the GitLab or Gitee token. Every hook passes if no secret is
+// set.
+func verifyHookSignature(header func(string) string, payload []byte) bool {
+	secret := settings.webhookSecret
+	if secret == "" {
+		return true
+	}
+	if signature := header(githubSignatureHeader); signature != "" {
+		return hmac.Equal([]byte(signature), []byte(githubSignature(secret, payload)))
+	}
+	for _, name := range []string{gitlabTokenHeader, giteeTokenHeader} {
+		if token := header(name); token != "" {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+		}
+	}
+	return false
+}
+
+// signHook signs a replayed delivery with the secret the way its VCS does, GitHub deliveries with
+// the payload signature and the others with the GitLab token.
+func signHook(req *http.Request, delivery HookDelivery, secret string) {
+	if secret == "" {
+		return
+	}
+	if githubEventOf(delivery) != "" {
+		req.Header.Set(githubSignatureHeader, githubSignature(secret, delivery.Payload))
+	} else {
+		req.Header.Set(gitlabTokenHeader, secret)
+	}
+}
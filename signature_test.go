@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyHookSignature(t *testing.T) {
+	saved := settings.webhookSecret
+	defer func() { settings.webhookSecret = saved }()
+	payload := []byte(`{"action":"closed"}`)
+	headers := func(pairs ...string) func(string) string {
+		return func(name string) string {
+			for i := 0; i < len(pairs); i += 2 {
+				if pairs[i] == name {
+					return pairs[i+1]
+				}
+			}
+			return ""
+		}
+	}
+
+	settings.webhookSecret = ""
+	if !verifyHookSignature(headers(), payload) {
+		t.Error("Hooks should not be checked without a webhook secret")
+	}
+	settings.webhookSecret = "hook-key"
+	testData := map[string]struct {
+		header func(string) string
+		valid  bool
+	}{
+		"github":        {headers(githubSignatureHeader, githubSignature("hook-key", payload)), true},
+		"github wrong":  {headers(githubSignatureHeader, githubSignature("other", payload)), false},
+		"gitlab":        {headers(gitlabTokenHeader, "hook-key"), true},
+		"gitee":         {headers(giteeTokenHeader, "hook-key"), true},
+		"gitee wrong":   {headers(giteeTokenHeader, "other"), false},
+		"not signed":    {headers(), false},
+		"github pinned": {headers(githubSignatureHeader, "sha256=00", gitlabTokenHeader, "hook-key"), false},
+	}
+	for name, data := range testData {
+		if verifyHookSignature(data.header, payload) != data.valid {
+			t.Errorf("%s: expected valid %v", name, data.valid)
+		}
+	}
+}
+
+func TestOnNotify_SignatureAndReplay(t *testing.T) {
+	savedSecret, savedWindow := settings.webhookSecret, settings.dedupWindowSeconds
+	defer func() { settings.webhookSecret, settings.dedupWindowSeconds = savedSecret, savedWindow }()
+	settings.webhookSecret, settings.dedupWindowSeconds = "hook-key", 60
+	resetDedupCache()
+	r := createGinEngine()
+	r.POST("/notify", onNotify)
+	payload := `{"hook_name":"unknown_hooks"}`
+	post := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/notify", strings.NewReader(payload))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "1004") {
+		t.Errorf("An unsigned hook should be refused, actual %d %s", w.Code, w.Body)
+	}
+	if w := post(gitlabTokenHeader, "hook-key"); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "duplicate") {
+		t.Errorf("A signed hook should be accepted, actual %d %s", w.Code, w.Body)
+	}
+	if w := post(gitlabTokenHeader, "hook-key"); !strings.Contains(w.Body.String(), "duplicate dropped") {
+		t.Errorf("The same hook should be dropped as a duplicate, actual %s", w.Body)
+	}
+	if w := post(gitlabTokenHeader, "hook-key", replayHeader, "delivery-1"); strings.Contains(w.Body.String(), "duplicate") {
+		t.Errorf("A replay should not be dropped as a duplicate, actual %s", w.Body)
+	}
+	if w := post(replayHeader, "delivery-1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("An unsigned replay should be refused, actual %d %s", w.Code, w.Body)
+	}
+
+	settings.webhookSecret = ""
+	if w := post(replayHeader, "delivery-1"); !strings.Contains(w.Body.String(), "duplicate dropped") {
+		t.Errorf("The replay header should be ignored without a webhook secret, actual %s", w.Body)
+	}
+	hookDispatches.Wait()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// runSimulate is the simulate subcommand, it runs a hook payload through agent selection, parsing
+// and project matching, and prints the Jenkins call that prcd would make. The call is only made
+// with -execute.
+func runSimulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configFile := flags.String("config", "/etc/prcd/projects.yaml", "Jenkins Project config file.")
+	flags.StringVar(configFile, "jenkins-project-config-file", "/etc/prcd/projects.yaml", "Jenkins Project config file, as -config.")
+	event := flags.String("event", "", "Hook name of the payload, e.g. merge_request_hooks or github_pull_request, read from the payload if empty.")
+	execute := flags.Bool("execute", false, "Really notify the matched Jenkins project.")
+	registerJenkinsFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: prcd simulate [options] <payload file, - for stdin>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	var err error
+	if settings.jenkinsUserApiToken, err = resolveSecret(settings.jenkinsUserApiToken); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	registerSecrets(settings.jenkinsUserApiToken)
+	// Keep stdout for the simulation.
+	logOutput = os.Stderr
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := loadJenkinsProjectConfig(*configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	hookName := *event
+	if hookName == "" {
+		basicHook := BasicHook{}
+		if err := json.Unmarshal(b, &basicHook); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid payload:", err)
+			return 1
+		}
+		hookName = basicHook.HookName
+	}
+
+	agent := createHookAgentByName(hookName)
+	fmt.Printf("hook: %s\nagent: %s\n", hookName, agent.Name())
+	if err := agent.Parse(b); err != nil {
+		fmt.Println("parse failed:", err)
+		return 1
+	}
+	project, branch, env := agent.HookProject(), agent.HookBranch(), agent.Environment()
+	fmt.Printf("project: %s\nbranch: %s\nenvironment: %s\ncan trigger: %t\n", project, branch, env, agent.CanTriggerEvent())
+	if _, ok := agent.(CommentAgent); ok {
+		fmt.Println("result: comment hooks run comment commands, nothing is notified")
+		return 0
+	}
+	if !agent.CanTriggerEvent() {
+		fmt.Println("result: the hook cannot trigger a deploy, nothing is notified")
+		return 0
+	}
+	notifier := createNotifierByAgent(agent)
+	if notifier.JenkinsProject.Name == "" || notifier.JenkinsProject.Token == "" {
+		fmt.Printf("result: %s, nothing is notified\n", unmatchedReason(env, project, branch))
+		return 0
+	}
+	user, _ := notifier.credentials()
+	fmt.Printf("jenkins project: %s\njenkins settings: %s\nnotify: POST %s\n", notifier.JenkinsProject.Name,
+		formatJenkinsSettings(notifier.jenkinsSettings()), redactString(notifier.notifyUrl()))
+	if notifier.DryRun {
+		fmt.Println("dry run: the entry is dry_run, -execute does not notify Jenkins")
+	}
+	if user != "" {
+		fmt.Printf("auth: basic, user %s\n", user)
+	}
+	if !*execute {
+		fmt.Println("result: not executed, run with -execute to notify Jenkins")
+		return 0
+	}
+	if err := notifier.Notify(); err != nil {
+		fmt.Println("result: notify failed:", err)
+		return 1
+	}
+	if notifier.DryRun {
+		fmt.Println("result: dry run, Jenkins is not notified")
+		return 0
+	}
+	fmt.Printf("result: notified, status %d, queue item %s\n", notifier.NotifyStatus, notifier.QueueUrl)
+	return 0
+}
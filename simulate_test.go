@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunSimulate(t *testing.T) {
+	saved, output := settings, logOutput
+	defer func() { settings, logOutput = saved, output }()
+	defer loadJenkinsProjectConfig("config/projects.sample.yaml")
+	var requests []string
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		w.Header().Set("Location", "/queue/item/1/")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer jenkins.Close()
+	config := filepath.Join(t.TempDir(), "projects.yaml")
+	ioutil.WriteFile(config, []byte(`
+release-mingdao:
+  environment: production
+  vcs_project: mingdao
+  branch: master
+  jenkins_project: pro
+  jenkins_token: abcd1234
+`), 0640)
+
+	args := []string{"-config", config, "-event", "github_pull_request", "-jenkins-host", jenkins.URL, "samples/github_pull_request.json"}
+	if code := runSimulate(args); code != 0 || len(requests) != 0 {
+		t.Errorf("simulate should not notify Jenkins without -execute, actual exit code %d, requests %v", code, requests)
+	}
+	if code := runSimulate(append([]string{"-execute"}, args...)); code != 0 || len(requests) != 1 ||
+		requests[0] != "POST /job/pro/build?token=abcd1234" {
+		t.Errorf("simulate -execute should notify Jenkins, actual exit code %d, requests %v", code, requests)
+	}
+
+	if code := runSimulate([]string{"-config", config, "samples/not-exists.json"}); code != 1 {
+		t.Errorf("simulate should fail without a payload file, actual exit code %d", code)
+	}
+	if code := runSimulate([]string{"-config", config, "samples/pull_request.json"}); code != 0 {
+		t.Errorf("simulate should read the hook name from the payload, actual exit code %d", code)
+	}
+	if code := runSimulate([]string{"-jenkins-project-config-file", config, "samples/pull_request.json"}); code != 0 {
+		t.Errorf("simulate should take the project config as explain does, actual exit code %d", code)
+	}
+	if code := runSimulate(nil); code != 2 {
+		t.Errorf("simulate should require a payload, actual exit code %d", code)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the hook pipeline. Spans are dropped unless setupTracing installs
+// an exporting provider.
+var tracer = otel.Tracer("github.com/akimimi/prcd")
+
+// setupTracing exports spans over OTLP/HTTP to endpoint ("host:port"), and installs the W3C trace
+// context propagator used on outgoing Jenkins requests. The returned function flushes and stops
+// the exporter.
+func setupTracing(endpoint string, insecure bool) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return func() {}, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("prcd"))),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Error("shutdown tracing failed", "error", err)
+		}
+	}, nil
+}
+
+// hookAttributes returns the span attributes of a parsed hook.
+func hookAttributes(agent HookAgent) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("prcd.agent", agent.Name()),
+		attribute.String("prcd.project", agent.HookProject()),
+		attribute.String("prcd.branch", agent.HookBranch()),
+		attribute.String("prcd.environment", agent.Environment()),
+	}
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the trace context of ctx to the headers of an outgoing request.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans routes the spans of the pipeline to a recorder for the duration of a test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestOnNotify_Spans(t *testing.T) {
+	recorder := recordSpans(t)
+	resetDedupCache()
+	settings.dedupWindowSeconds = 10
+	r := createGinEngine()
+	r.POST("/notify", onNotify)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/notify", strings.NewReader(`not json`)))
+	receive := findSpan(recorder.Ended(), "receive hook")
+	if receive == nil || receive.Status().Code.String() != "Error" {
+		t.Fatalf("A failed receive hook span should be recorded, actual %v", recorder.Ended())
+	}
+	dedup := findSpan(recorder.Ended(), "dedup check")
+	if dedup == nil || dedup.Parent().SpanID() != receive.SpanContext().SpanID() {
+		t.Errorf("The dedup check span should be a child of the receive hook span")
+	}
+	if spanAttribute(dedup, "prcd.duplicate").AsBool() {
+		t.Errorf("The first payload should not be a duplicate")
+	}
+}
+
+func TestSendNotice_Spans(t *testing.T) {
+	recorder := recordSpans(t)
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	b, err := ioutil.ReadFile("samples/pull_request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = []byte(strings.Replace(string(b), `"state": "open"`, `"state": "merged"`, 1))
+	agent := createHookAgentByName("merge_request_hooks")
+	if err := agent.Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	jenkinsProjectConfigGrp = map[string]JenkinsProjectConfig{"traced": {
+		Environment: agent.Environment(), VcsProject: agent.HookProject(), Branch: agent.HookBranch(),
+		JenkinsProject: "pro", JenkinsToken: "abcd1234",
+	}}
+	defer func() { jenkinsProjectConfigGrp = nil }()
+	notificationChannelGrp = nil
+	settings.jenkinsHost, settings.jenkinsNotifyUrl = ts.URL, "/<project>/notify?token=<token>"
+
+	ctx, root := tracer.Start(context.Background(), "receive hook")
+	sendNotice(ctx, "delivery-1", BasicHook{HookName: "merge_request_hooks", HookId: 7}, b)
+	root.End()
+
+	parse := findSpan(recorder.Ended(), "agent parse")
+	if parse == nil || spanAttribute(parse, "prcd.project").AsString() != agent.HookProject() ||
+		spanAttribute(parse, "prcd.correlation_id").AsString() != "delivery-1" {
+		t.Fatalf("The agent parse span should carry the hook attributes, actual %v", parse)
+	}
+	match := findSpan(recorder.Ended(), "match project")
+	if match == nil || spanAttribute(match, "prcd.jenkins_project").AsString() != "pro" ||
+		spanAttribute(match, "prcd.hook_id").AsInt64() != 7 {
+		t.Fatalf("The match project span should carry the matched Jenkins project, actual %v", match)
+	}
+	notify := findSpan(recorder.Ended(), "jenkins notify")
+	if notify == nil || notify.SpanKind() != trace.SpanKindClient ||
+		spanAttribute(notify, "http.status_code").AsInt64() != http.StatusCreated {
+		t.Fatalf("The jenkins notify span should be recorded, actual %v", notify)
+	}
+	for _, span := range []sdktrace.ReadOnlySpan{parse, match, notify} {
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("Span %s should be in the trace of the hook", span.Name())
+		}
+	}
+	expected := "00-" + root.SpanContext().TraceID().String() + "-" + notify.SpanContext().SpanID().String() + "-01"
+	if traceparent != expected {
+		t.Errorf("Jenkins request should propagate the trace context, expected %s, actual %s", expected, traceparent)
+	}
+}
+
+func TestSetupTracing_ExportsToCollector(t *testing.T) {
+	received := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- r.Method + " " + r.URL.Path:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	shutdown, err := setupTracing(strings.TrimPrefix(collector.URL, "http://"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "receive hook")
+	span.End()
+	shutdown()
+
+	select {
+	case request := <-received:
+		if request != "POST /v1/traces" {
+			t.Errorf("Spans should be exported over OTLP/HTTP, actual %s", request)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("No spans exported to the collector")
+	}
+}